@@ -1,17 +1,123 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
 	"orchestrator/internal/api"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"orchestrator/internal/database"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/logging"
+	"orchestrator/internal/prompts"
+	"orchestrator/internal/ratelimit"
+	"orchestrator/internal/tracing"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	err := godotenv.Load()
+	// A .env file is a convenience for local runs; deployments set the environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fatal("Error loading .env file", err)
+	}
+	cfg, err := config.Load(os.Getenv("ORCHESTRATOR_CONFIG"))
+	if err == nil {
+		err = errors.Join(llm.ValidateConfig(cfg.LLM), api.ValidateConfig(cfg.HTTP))
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Logging.Level, cfg.Logging.Format); err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		fatal("Invalid tracing configuration", err)
+	}
+	defer shutdownTracing(context.Background())
+	if err := prompts.Load(cfg.Prompts.Dir); err != nil {
+		fatal("Error loading prompt templates", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(context.Background()); err != nil {
+		fatal("Error migrating database", err)
+	}
+	if err := database.CheckSQLRole(context.Background()); err != nil {
+		slog.Error("Generated SQL queries will fail until the SQL role is set up", "role", cfg.Database.SQLRole, "error", err)
+	}
+	if key := cfg.Auth.BootstrapAdminKey; key != "" {
+		if err := auth.Bootstrap(context.Background(), key); err != nil {
+			fatal("Error storing bootstrap admin key", err)
+		}
+	}
+	authenticator, err := auth.NewAuthenticator(auth.JWTConfig{
+		Secret:   cfg.Auth.JWT.Secret,
+		JWKSFile: cfg.Auth.JWT.JWKSFile,
+		Issuer:   cfg.Auth.JWT.Issuer,
+		Audience: cfg.Auth.JWT.Audience,
+	})
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fatal("Invalid JWT configuration", err)
 	}
-	r := api.SetupRouter()
-	r.Run(":8080")
+	limits, err := ratelimit.LoadConfig(cfg.RateLimits.File)
+	if err != nil {
+		fatal("Invalid rate limits", err)
+	}
+	limiter := ratelimit.NewLimiter(limits)
+	if cfg.RateLimits.File != "" {
+		go limiter.Watch(context.Background(), cfg.RateLimits.File, 10*time.Second)
+	}
+	server := &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           api.SetupRouter(authenticator, limiter),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("Listening", "address", server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+	var grpcServer *grpc.Server
+	if address := cfg.Server.GRPCListen; address != "" {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			fatal("Error listening for gRPC", err)
+		}
+		grpcServer = api.NewGRPCServer(authenticator, limiter)
+		go func() {
+			slog.Info("Listening for gRPC", "address", listener.Addr())
+			serverErr <- grpcServer.Serve(listener)
+		}()
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	select {
+	case err := <-serverErr:
+		fatal("Server stopped", err)
+	case <-stop.Done():
+	}
+
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancelShutdown()
+	if err := api.Drain(ctx, server, grpcServer); err != nil {
+		slog.Error("Shutdown did not complete in time", "error", err)
+		return
+	}
+	slog.Info("Shut down cleanly")
+}
+
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
 }
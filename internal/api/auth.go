@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authMiddleware authenticates the API key or JWT sent as a bearer token, or
+// the API key sent in X-API-Key
+func authMiddleware(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := credentials(c.GetHeader("X-API-Key"), c.GetHeader("Authorization"))
+		identity, err := authenticator.Authenticate(c.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, auth.ErrUnauthenticated) {
+				c.Header("WWW-Authenticate", "Bearer")
+			}
+			abortWithError(c, err)
+			return
+		}
+
+		c.Set(gin.AuthUserKey, identity.Subject)
+		c.Request = c.Request.WithContext(auth.WithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// credentials returns the API key, or else the bearer token of the
+// authorization header
+func credentials(apiKey string, authorization string) string {
+	if apiKey != "" || authorization == "" {
+		return apiKey
+	}
+	scheme, token, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// requireRole rejects callers without the role
+func requireRole(role auth.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := auth.FromContext(c.Request.Context())
+		if !ok || !identity.HasRole(role) {
+			abortWithError(c, fmt.Errorf("%w: requires role %s", auth.ErrForbidden, role))
+			return
+		}
+		c.Next()
+	}
+}
+
+func handleCreateAPIKey(c *gin.Context) {
+	var request models.CreateAPIKeyRequest
+	if !bindRequest(c, &request, bindOnly) {
+		return
+	}
+	roles, err := auth.ParseRoles(request.Roles)
+	if err != nil {
+		abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "roles", Message: err.Error()}}})
+		return
+	}
+
+	tenant := request.Tenant
+	var userID *int64
+	if request.UserID != 0 {
+		user, err := auth.GetUser(c.Request.Context(), request.UserID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "user_id", Message: "is not a user"}}})
+			return
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		if tenant != "" && tenant != user.Tenant {
+			abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "tenant", Message: "must be the tenant of the user"}}})
+			return
+		}
+		tenant, userID = user.Tenant, &user.ID
+	}
+	if tenant == "" {
+		tenant = auth.DefaultTenant
+	}
+	key, record, err := auth.CreateAPIKey(c.Request.Context(), request.Name, tenant, userID, roles)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{Key: key, APIKey: auth.APIKeyInfo(record)})
+}
+
+func handleListAPIKeys(c *gin.Context) {
+	keys, err := auth.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func handleRevokeAPIKey(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	err := auth.RevokeAPIKey(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// idParam parses the id path parameter. Invalid ids are answered with 400,
+// and false is returned
+func idParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "id", Message: "must be an integer"}}})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/models"
+	"orchestrator/internal/ratelimit"
+	"orchestrator/internal/validation"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	chatSocketPath = "/v1/query/chat/ws"
+	// Messages on the socket count against the quota of the chat route
+	chatRoute = "/v1/query/chat"
+
+	socketWriteWait    = 10 * time.Second
+	socketPongWait     = time.Minute
+	socketPingInterval = 50 * time.Second
+)
+
+var errAnswerInProgress = errors.New("an answer is still being generated; send cancel to abort it")
+
+// sockets are the open chat WebSockets. Shutdown closes them with 1001 Going
+// Away once the answers being generated are done
+var sockets = struct {
+	sync.Mutex
+	conns map[*websocket.Conn]struct{}
+}{conns: make(map[*websocket.Conn]struct{})}
+
+// handleChatSocket upgrades to a WebSocket on which the caller chats within a
+// conversation. Each message is answered like POST /v1/query/chat, with the
+// answer streamed as it is generated. The conversation_id query parameter
+// resumes a conversation; otherwise one is started by the first message
+func handleChatSocket(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var conversationID int64
+		if value := c.Query("conversation_id"); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "conversation_id", Message: "must be a positive integer"}}})
+				return
+			}
+			if conversationID, err = llm.StartConversation(ctx, id, ""); err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		settings := config.Get().HTTP
+		upgrader := websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool { return socketOriginAllowed(settings.CORS, r) },
+			Error: func(_ http.ResponseWriter, _ *http.Request, status int, reason error) {
+				if status == http.StatusForbidden {
+					abortWithError(c, fmt.Errorf("%w: %w", auth.ErrForbidden, reason))
+					return
+				}
+				abortWithError(c, &validation.Error{Fields: []models.FieldError{{Field: "headers", Message: reason.Error()}}})
+			},
+		}
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+
+		readLimit := settings.MaxBodyBytes
+		if limit, ok := settings.RouteBodyBytes[chatSocketPath]; ok {
+			readLimit = limit
+		}
+		conn.SetReadLimit(int64(readLimit))
+		identity, _ := auth.FromContext(ctx)
+		session := &chatSession{conn: conn, limiter: limiter, identity: identity, conversationID: conversationID}
+		session.run(ctx)
+	}
+}
+
+// socketOriginAllowed lets pages of the API's own origin and of the CORS
+// origins open sockets, as well as clients that are not browsers
+func socketOriginAllowed(cors config.CORS, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || originAllowed(cors, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// chatSession is a chat WebSocket. It answers one message at a time
+type chatSession struct {
+	conn     *websocket.Conn
+	limiter  *ratelimit.Limiter
+	identity auth.Identity
+	// Only written by the answer in progress; zero until the first answer starts a conversation
+	conversationID int64
+
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// Aborts the answer in progress; nil while idle
+	cancel   context.CancelFunc
+	canceled bool
+	answers  sync.WaitGroup
+}
+
+// run reads the client's messages until the socket is closed, and then
+// aborts the answer in progress
+func (s *chatSession) run(ctx context.Context) {
+	sockets.Lock()
+	sockets.conns[s.conn] = struct{}{}
+	sockets.Unlock()
+	defer func() {
+		s.abort()
+		s.answers.Wait()
+		sockets.Lock()
+		delete(sockets.conns, s.conn)
+		sockets.Unlock()
+		s.conn.Close()
+	}()
+
+	// Clients that stop answering pings are disconnected
+	_ = s.conn.SetReadDeadline(time.Now().Add(socketPongWait))
+	s.conn.SetPongHandler(func(string) error { return s.conn.SetReadDeadline(time.Now().Add(socketPongWait)) })
+	stopPings := s.ping()
+	defer stopPings()
+
+	if s.conversationID != 0 {
+		s.send(models.ChatSocketEvent{Type: "session", ConversationID: s.conversationID})
+	}
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				slog.DebugContext(ctx, "WebSocket closed", "error", err)
+			}
+			return
+		}
+		s.receive(ctx, data)
+	}
+}
+
+func (s *chatSession) receive(ctx context.Context, data []byte) {
+	var message models.ChatSocketMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		s.sendError(ctx, invalidRequest(err))
+		return
+	}
+
+	switch message.Type {
+	case "cancel":
+		s.abort()
+	case "message":
+		request := models.LLMSimpleQueryRequest{Input: message.Input, Model: message.Model, Options: message.Options}
+		if err := validation.LLMSimpleQueryRequest(ctx, &request); err != nil {
+			s.sendError(ctx, err)
+			return
+		}
+		if draining.Load() {
+			goAway(s.conn)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.cancel != nil {
+			s.sendError(ctx, errAnswerInProgress)
+			return
+		}
+		// Shutdown waits for the answer like it does for background jobs
+		ctx, finished := startJob(ctx, "socket_chat")
+		ctx, s.cancel = context.WithCancel(ctx)
+		s.answers.Add(1)
+		go func() {
+			defer s.answers.Done()
+			result, err := s.answer(ctx, request)
+			s.reply(ctx, result, err)
+			finished(err)
+		}()
+	default:
+		s.sendError(ctx, &validation.Error{Fields: []models.FieldError{{Field: "type", Message: `must be "message" or "cancel"`}}})
+	}
+}
+
+// answer streams the answer to a message, and saves both to the conversation
+func (s *chatSession) answer(ctx context.Context, request models.LLMSimpleQueryRequest) (*models.LLMResponse, error) {
+	_, release, err := s.limiter.Acquire(callerID(s.identity), s.identity.Subject, chatRoute)
+	if err != nil {
+		reason := "rate"
+		if errors.Is(err, ratelimit.ErrConcurrencyLimited) {
+			reason = "concurrency"
+		}
+		metrics.RateLimited.WithLabelValues(chatRoute, reason).Inc()
+		return nil, err
+	}
+	defer release()
+
+	if s.conversationID == 0 {
+		if s.conversationID, err = llm.StartConversation(ctx, 0, "Chat: "+request.Input); err != nil {
+			return nil, err
+		}
+		s.send(models.ChatSocketEvent{Type: "session", ConversationID: s.conversationID})
+	}
+	request.ConversationID = s.conversationID
+
+	ctx, collector := llm.WithCollector(ctx)
+	ctx = llm.WithTokenHandler(ctx, func(token string) {
+		s.send(models.ChatSocketEvent{Type: "token", Token: token})
+	})
+	ctx = llm.WithStageHandler(ctx, func(event llm.StageEvent) {
+		s.send(models.ChatSocketEvent{Type: "stage", Stage: string(event.Stage), Status: event.Status, DurationMs: event.Duration.Milliseconds()})
+	})
+	response, err := llm.ProcessLLMSimpleQuery(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	result := newLLMResponse(ctx, response, collector)
+	return &result, nil
+}
+
+// reply ends the answer in progress and then tells the client how it went, so
+// that the next message is accepted as soon as the client hears of it
+func (s *chatSession) reply(ctx context.Context, result *models.LLMResponse, err error) {
+	s.mu.Lock()
+	canceled := s.canceled
+	s.cancel()
+	s.cancel, s.canceled = nil, false
+	s.mu.Unlock()
+
+	switch {
+	case canceled && errors.Is(err, context.Canceled):
+		s.send(models.ChatSocketEvent{Type: "canceled"})
+	case err != nil:
+		s.sendError(ctx, err)
+	default:
+		s.send(models.ChatSocketEvent{Type: "done", Response: result})
+	}
+}
+
+// abort cancels the answer in progress, if there is one
+func (s *chatSession) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+		s.canceled = true
+	}
+}
+
+// send writes an event. A client that cannot keep up is disconnected, which
+// ends the session
+func (s *chatSession) send(event models.ChatSocketEvent) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_ = s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+	if err := s.conn.WriteJSON(event); err != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *chatSession) sendError(ctx context.Context, err error) {
+	_, response := errorResponse(ctx, err)
+	s.send(models.ChatSocketEvent{Type: "error", Error: &response})
+}
+
+// ping keeps the connection alive through proxies and detects dead clients
+// until stop is called
+func (s *chatSession) ping() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(socketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// goAway closes the socket with 1001 Going Away
+func goAway(conn *websocket.Conn) {
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is shutting down")
+	_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteWait))
+	conn.Close()
+}
+
+// closeSockets closes the open chat WebSockets with 1001 Going Away
+func closeSockets() {
+	sockets.Lock()
+	defer sockets.Unlock()
+	for conn := range sockets.conns {
+		goAway(conn)
+	}
+}
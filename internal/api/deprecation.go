@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// legacyDeprecatedAt is when the paths served before /v1 were deprecated
+var legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecationMiddleware marks responses of a legacy path as deprecated
+// (RFC 9745) and links the /v1 route that replaces it. The Sunset header
+// (RFC 8594) is sent once a sunset date is configured
+func deprecationMiddleware(successor string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "@"+strconv.FormatInt(legacyDeprecatedAt.Unix(), 10))
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", "<"+successorPath(c, successor)+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
+
+// successorPath fills the parameters of the successor route from the request
+func successorPath(c *gin.Context, successor string) string {
+	segments := strings.Split(successor, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = c.Param(name)
+		}
+	}
+	return strings.Join(segments, "/")
+}
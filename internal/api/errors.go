@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/database"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/logging"
+	"orchestrator/internal/models"
+	"orchestrator/internal/ratelimit"
+	"orchestrator/internal/resilience"
+	"orchestrator/internal/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/v10"
+	"google.golang.org/grpc/codes"
+)
+
+// Codes of error responses
+const (
+	codeInvalidRequest      = "invalid_request"
+	codeUnauthenticated     = "unauthenticated"
+	codeForbidden           = "forbidden"
+	codeNotFound            = "not_found"
+	codeModelNotFound       = "model_not_found"
+	codeConflict            = "conflict"
+	codePayloadTooLarge     = "payload_too_large"
+	codePromptTooLarge      = "prompt_too_large"
+	codeSQLRejected         = "sql_rejected"
+	codeRateLimited         = "rate_limited"
+	codeCanceled            = "canceled"
+	codeInternal            = "internal"
+	codeUpstreamUnavailable = "upstream_unavailable"
+	codeTimeout             = "timeout"
+)
+
+// statusClientClosedRequest is logged when the client went away before the answer was ready
+const statusClientClosedRequest = 499
+
+// errorClass maps a kind of error to its response
+type errorClass struct {
+	matches func(error) bool
+	status  int
+	code    string
+	// Status code of gRPC calls failing this way
+	grpcCode codes.Code
+	// Shown instead of the error when it may contain details of our dependencies
+	message string
+}
+
+func is(target error) func(error) bool {
+	return func(err error) bool { return errors.Is(err, target) }
+}
+
+// errorClasses are checked in order; the first match decides the response
+var errorClasses = []errorClass{
+	{func(err error) bool { var v *validation.Error; return errors.As(err, &v) }, http.StatusBadRequest, codeInvalidRequest, codes.InvalidArgument, "invalid request"},
+	{is(llm.ErrInvalidInput), http.StatusBadRequest, codeInvalidRequest, codes.InvalidArgument, "invalid request"},
+	{is(database.ErrInvalidRowKey), http.StatusBadRequest, codeInvalidRequest, codes.InvalidArgument, "invalid request"},
+	{is(auth.ErrSelfShare), http.StatusBadRequest, codeInvalidRequest, codes.InvalidArgument, "invalid request"},
+	{is(auth.ErrUnauthenticated), http.StatusUnauthorized, codeUnauthenticated, codes.Unauthenticated, "missing or invalid credentials"},
+	{is(auth.ErrForbidden), http.StatusForbidden, codeForbidden, codes.PermissionDenied, "insufficient permissions"},
+	{is(llm.ErrModelNotFound), http.StatusNotFound, codeModelNotFound, codes.NotFound, "model not found"},
+	{is(database.ErrNotFound), http.StatusNotFound, codeNotFound, codes.NotFound, "not found"},
+	{is(errNoRoute), http.StatusNotFound, codeNotFound, codes.NotFound, "no such endpoint"},
+	{is(auth.ErrKeyNotFound), http.StatusNotFound, codeNotFound, codes.NotFound, "API key not found"},
+	{is(auth.ErrShareNotFound), http.StatusNotFound, codeNotFound, codes.NotFound, "share not found"},
+	{func(err error) bool { var v *http.MaxBytesError; return errors.As(err, &v) }, http.StatusRequestEntityTooLarge, codePayloadTooLarge, codes.ResourceExhausted, "request body too large"},
+	{is(llm.ErrPromptTooLarge), http.StatusRequestEntityTooLarge, codePromptTooLarge, codes.InvalidArgument, "the prompt does not fit the model's context window"},
+	{is(database.ErrUserExists), http.StatusConflict, codeConflict, codes.AlreadyExists, "user already exists"},
+	{is(errAnswerInProgress), http.StatusConflict, codeConflict, codes.FailedPrecondition, "an answer is still being generated"},
+	{is(llm.ErrSQLRejected), http.StatusUnprocessableEntity, codeSQLRejected, codes.FailedPrecondition, "generated SQL query was rejected"},
+	{is(ratelimit.ErrRateLimited), http.StatusTooManyRequests, codeRateLimited, codes.ResourceExhausted, "rate limit exceeded"},
+	{is(ratelimit.ErrConcurrencyLimited), http.StatusTooManyRequests, codeRateLimited, codes.ResourceExhausted, "too many concurrent requests"},
+	{is(context.DeadlineExceeded), http.StatusGatewayTimeout, codeTimeout, codes.DeadlineExceeded, "the request took too long"},
+	{is(context.Canceled), statusClientClosedRequest, codeCanceled, codes.Canceled, "the request was canceled"},
+	{is(llm.ErrUpstreamUnavailable), http.StatusServiceUnavailable, codeUpstreamUnavailable, codes.Unavailable, "the model server is unavailable"},
+	{is(resilience.ErrCircuitOpen), http.StatusServiceUnavailable, codeUpstreamUnavailable, codes.Unavailable, "a dependency is unavailable"},
+	{is(database.ErrUnavailable), http.StatusServiceUnavailable, codeUpstreamUnavailable, codes.Unavailable, "the database is unavailable"},
+	// Dependencies that cannot be reached, such as Postgres when the shared pool connects
+	{func(err error) bool { var opErr *net.OpError; return errors.As(err, &opErr) }, http.StatusServiceUnavailable, codeUpstreamUnavailable, codes.Unavailable, "a dependency is unavailable"},
+}
+
+// abortWithError answers with the error envelope. Server-side failures are
+// logged, and only their generic message is returned
+func abortWithError(c *gin.Context, err error) {
+	status, response := errorResponse(c.Request.Context(), err)
+	c.AbortWithStatusJSON(status, models.ErrorResponse{Error: response})
+}
+
+// errorResponse returns the status and error envelope answering err
+func errorResponse(ctx context.Context, err error) (int, models.APIError) {
+	class, message := classify(ctx, err)
+	response := models.APIError{Code: class.code, Message: message, RequestID: logging.RequestID(ctx)}
+	var invalid *validation.Error
+	if errors.As(err, &invalid) {
+		response.Message = "invalid request"
+		response.Fields = invalid.Fields
+	}
+	return class.status, response
+}
+
+// classify returns the class of the error and the message to show the
+// caller, and logs server-side failures
+func classify(ctx context.Context, err error) (errorClass, string) {
+	class, message := classOf(err)
+	if class.status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "Request failed", "code", class.code, "error", err)
+	} else if class.status == http.StatusUnprocessableEntity {
+		slog.WarnContext(ctx, "Request failed", "code", class.code, "error", err)
+	}
+	return class, message
+}
+
+// classOf returns the class of the error and the message to show the caller
+func classOf(err error) (errorClass, string) {
+	for _, class := range errorClasses {
+		if class.matches(err) {
+			if exposesInternals(err) {
+				return class, class.message
+			}
+			return class, err.Error()
+		}
+	}
+	return errorClass{status: http.StatusInternalServerError, code: codeInternal, grpcCode: codes.Internal, message: "internal error"}, "internal error"
+}
+
+// publicTrace replaces the errors in a RAG trace with the messages callers may see
+func publicTrace(trace *models.RAGTrace) {
+	if trace == nil {
+		return
+	}
+	if trace.FallbackErr != nil {
+		_, trace.FallbackReason = classOf(trace.FallbackErr)
+	}
+	for i, result := range trace.SubResults {
+		if result.Err != nil {
+			_, trace.SubResults[i].Error = classOf(result.Err)
+		}
+	}
+}
+
+// exposesInternals reports whether the error text comes from a dependency,
+// such as a Postgres or Ollama error message, or is a server-side failure
+func exposesInternals(err error) bool {
+	var statusErr *resilience.StatusError
+	var pgErr pg.Error
+	var opErr *net.OpError
+	return errors.As(err, &statusErr) || errors.As(err, &pgErr) || errors.As(err, &opErr) ||
+		errors.Is(err, database.ErrUnavailable) || errors.Is(err, llm.ErrUpstreamUnavailable) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+var errNoRoute = errors.New("no such endpoint")
+
+// handleNoRoute answers unknown paths with the error envelope
+func handleNoRoute(c *gin.Context) {
+	abortWithError(c, fmt.Errorf("%w: %s %s", errNoRoute, c.Request.Method, c.Request.URL.Path))
+}
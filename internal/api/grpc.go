@@ -0,0 +1,360 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/logging"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/ratelimit"
+	"orchestrator/internal/validation"
+	orchestratorv1 "orchestrator/pkg/proto/orchestrator/v1"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const requestIDMetadata = "x-request-id"
+
+// grpcRoutes maps each gRPC method to the REST route it mirrors. Calls need
+// the role of the route and count against its rate limit and body size limit
+var grpcRoutes = map[string]string{
+	orchestratorv1.Orchestrator_EmbedRow_FullMethodName:          "/v1/embeddings/rows",
+	orchestratorv1.Orchestrator_EmbedDocument_FullMethodName:     "/v1/embeddings/documents",
+	orchestratorv1.Orchestrator_Chat_FullMethodName:              "/v1/query/chat",
+	orchestratorv1.Orchestrator_RAGQuery_FullMethodName:          "/v1/query/rag",
+	orchestratorv1.Orchestrator_MultiNodeRAGQuery_FullMethodName: "/v1/query/rag/multi",
+	orchestratorv1.Orchestrator_SQLQuery_FullMethodName:          "/v1/query/sql",
+}
+
+// grpcMethod is what a call needs to pass before it is handled
+type grpcMethod struct {
+	route    string
+	role     auth.Role
+	maxBytes int
+}
+
+// NewGRPCServer serves the embedding and query routes over gRPC, with the
+// credentials, roles, rate limits and errors of the REST API
+func NewGRPCServer(authenticator *auth.Authenticator, limiter *ratelimit.Limiter) *grpc.Server {
+	settings := config.Get().HTTP
+	gate := &grpcGate{authenticator: authenticator, limiter: limiter, methods: make(map[string]grpcMethod)}
+	maxBytes := settings.MaxBodyBytes
+	for _, r := range routes(limiter) {
+		for fullMethod, path := range grpcRoutes {
+			if r.method != http.MethodPost || r.path != path {
+				continue
+			}
+			method := grpcMethod{route: path, role: r.role, maxBytes: settings.MaxBodyBytes}
+			if limit, ok := settings.RouteBodyBytes[path]; ok {
+				method.maxBytes = limit
+				maxBytes = max(maxBytes, limit)
+			}
+			gate.methods[fullMethod] = method
+		}
+	}
+
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// Larger messages are refused before they are read; the limit of each method is checked by the gate
+		grpc.MaxRecvMsgSize(maxBytes),
+		grpc.ChainUnaryInterceptor(gate.unary),
+		grpc.ChainStreamInterceptor(gate.stream),
+	)
+	orchestratorv1.RegisterOrchestratorServer(server, grpcServer{})
+	return server
+}
+
+// grpcGate does for gRPC calls what the middleware does for REST requests
+type grpcGate struct {
+	authenticator *auth.Authenticator
+	limiter       *ratelimit.Limiter
+	methods       map[string]grpcMethod
+}
+
+func (g *grpcGate) unary(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response any, err error) {
+	err = g.serve(ctx, info.FullMethod, func(ctx context.Context, method grpcMethod) error {
+		if message, ok := request.(proto.Message); ok && proto.Size(message) > method.maxBytes {
+			return &http.MaxBytesError{Limit: int64(method.maxBytes)}
+		}
+		var err error
+		response, err = handler(ctx, request)
+		return err
+	})
+	return response, err
+}
+
+func (g *grpcGate) stream(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return g.serve(stream.Context(), info.FullMethod, func(ctx context.Context, method grpcMethod) error {
+		return handler(server, &grpcStream{ServerStream: stream, ctx: ctx, maxBytes: method.maxBytes})
+	})
+}
+
+// serve tags the call with a request ID, authenticates the caller, checks
+// its role and rate limit, and then handles the call. Errors are turned into
+// statuses, panics are recovered, and every call is logged and counted
+func (g *grpcGate) serve(ctx context.Context, fullMethod string, handle func(context.Context, grpcMethod) error) (err error) {
+	start := time.Now()
+	requestID := logging.RequestIDOrNew(firstMetadata(ctx, requestIDMetadata))
+	ctx = logging.WithRequestID(ctx, requestID)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", requestID))
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDMetadata, requestID))
+
+	var user string
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.ErrorContext(ctx, "Recovered from panic", "error", recovered, "stack", string(debug.Stack()))
+			err = grpcStatus(ctx, fmt.Errorf("panic: %v", recovered))
+		}
+
+		code := status.Code(err)
+		level := slog.LevelInfo
+		if code == codes.Internal || code == codes.Unknown || code == codes.Unavailable || code == codes.DeadlineExceeded {
+			level = slog.LevelError
+		}
+		var client string
+		if p, ok := peer.FromContext(ctx); ok {
+			client = p.Addr.String()
+		}
+		slog.Log(ctx, level, "Handled RPC",
+			"method", fullMethod,
+			"code", code.String(),
+			"duration", time.Since(start),
+			"peer", client,
+			"user", user,
+		)
+		metrics.GRPCRequests.WithLabelValues(fullMethod, code.String()).Inc()
+		metrics.GRPCRequestDuration.WithLabelValues(fullMethod).Observe(time.Since(start).Seconds())
+	}()
+
+	method, ok := g.methods[fullMethod]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "method %s is not served", fullMethod)
+	}
+
+	identity, err := g.authenticator.Authenticate(ctx, credentials(firstMetadata(ctx, "x-api-key"), firstMetadata(ctx, "authorization")))
+	if err != nil {
+		return grpcStatus(ctx, err)
+	}
+	user = identity.Subject
+	ctx = auth.WithIdentity(ctx, identity)
+	if !identity.HasRole(method.role) {
+		return grpcStatus(ctx, fmt.Errorf("%w: requires role %s", auth.ErrForbidden, method.role))
+	}
+
+	if method.role == auth.RoleQuery {
+		decision, release, err := g.limiter.Acquire(callerID(identity), identity.Subject, method.route)
+		if err != nil {
+			reason := "rate"
+			if errors.Is(err, ratelimit.ErrConcurrencyLimited) {
+				reason = "concurrency"
+			}
+			metrics.RateLimited.WithLabelValues(method.route, reason).Inc()
+			return grpcStatus(ctx, err, &errdetails.RetryInfo{RetryDelay: durationpb.New(decision.RetryAfter)})
+		}
+		defer release()
+	}
+
+	if err := handle(ctx, method); err != nil {
+		return grpcStatus(ctx, err)
+	}
+	return nil
+}
+
+// grpcStatus turns an error into the status of the failed call, with the
+// message the REST API would answer with. The request ID and any field
+// errors are attached as details
+func grpcStatus(ctx context.Context, err error, details ...protoadapt.MessageV1) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	class, message := classify(ctx, err)
+	var invalid *validation.Error
+	if errors.As(err, &invalid) {
+		message = "invalid request"
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(invalid.Fields))
+		for i, field := range invalid.Fields {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message}
+		}
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+	details = append(details, &errdetails.RequestInfo{RequestId: logging.RequestID(ctx)})
+
+	s, detailsErr := status.New(class.grpcCode, message).WithDetails(details...)
+	if detailsErr != nil {
+		return status.Error(class.grpcCode, message)
+	}
+	return s.Err()
+}
+
+func firstMetadata(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// grpcStream carries the context of the gate to the handler, and applies the
+// size limit of the method to the request
+type grpcStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	maxBytes int
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *grpcStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if message, ok := m.(proto.Message); ok && proto.Size(message) > s.maxBytes {
+		return &http.MaxBytesError{Limit: int64(s.maxBytes)}
+	}
+	return nil
+}
+
+// grpcServer implements the gRPC service on top of the same request types,
+// validation and LLM calls as the REST handlers
+type grpcServer struct {
+	orchestratorv1.UnimplementedOrchestratorServer
+}
+
+// EmbedRow embeds the row before it returns, unlike its REST route, which
+// leaves the work to a background job
+func (grpcServer) EmbedRow(ctx context.Context, in *orchestratorv1.RowEmbeddingsRequest) (*orchestratorv1.EmbeddingsResponse, error) {
+	request, err := rowEmbeddingsRequest(in)
+	if err == nil {
+		err = validation.RowEmbeddingsRequest(ctx, &request)
+	}
+	if err == nil {
+		err = llm.ProcessRowEmbeddings(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &orchestratorv1.EmbeddingsResponse{}, nil
+}
+
+// EmbedDocument embeds the document before it returns, unlike its REST
+// route, which leaves the work to a background job
+func (grpcServer) EmbedDocument(ctx context.Context, in *orchestratorv1.DocumentEmbeddingsRequest) (*orchestratorv1.EmbeddingsResponse, error) {
+	request := documentEmbeddingsRequest(in)
+	err := validation.DocumentEmbeddingsRequest(ctx, &request)
+	if err == nil {
+		err = llm.ProcessDocumentEmbeddingsInChunks(ctx, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &orchestratorv1.EmbeddingsResponse{}, nil
+}
+
+func (grpcServer) Chat(in *orchestratorv1.ChatRequest, stream orchestratorv1.Orchestrator_ChatServer) error {
+	request, err := simpleQueryRequest(in)
+	if err == nil {
+		err = validation.LLMSimpleQueryRequest(stream.Context(), &request)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Stop generating once the client can no longer be sent the answer
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	var sendErr error
+	ctx = llm.WithTokenHandler(ctx, func(token string) {
+		if sendErr == nil {
+			sendErr = stream.Send(&orchestratorv1.ChatResponse{Event: &orchestratorv1.ChatResponse_Token{Token: token}})
+			if sendErr != nil {
+				cancel()
+			}
+		}
+	})
+
+	ctx, collector := llm.WithCollector(ctx)
+	response, err := llm.ProcessLLMSimpleQuery(ctx, request)
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return err
+	}
+	done := queryResponse(newLLMResponse(ctx, response, collector))
+	return stream.Send(&orchestratorv1.ChatResponse{Event: &orchestratorv1.ChatResponse_Done{Done: done}})
+}
+
+func (grpcServer) RAGQuery(ctx context.Context, in *orchestratorv1.RAGQueryRequest) (*orchestratorv1.QueryResponse, error) {
+	request, err := ragQueryRequest(in)
+	if err == nil {
+		err = validation.LLMRAGQueryRequest(ctx, &request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, collector := llm.WithCollector(ctx)
+	response, err := llm.ProcessLLMRAGQuerySingleNode(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return queryResponse(newLLMResponse(ctx, response, collector)), nil
+}
+
+func (grpcServer) MultiNodeRAGQuery(ctx context.Context, in *orchestratorv1.RAGQueryRequest) (*orchestratorv1.MultiNodeRAGQueryResponse, error) {
+	request, err := ragQueryRequest(in)
+	if err == nil {
+		err = validation.LLMRAGQueryRequest(ctx, &request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, collector := llm.WithCollector(ctx)
+	response, err := llm.ProcessLLMRAGQueryMultiNode(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	publicTrace(response.Trace)
+	return &orchestratorv1.MultiNodeRAGQueryResponse{
+		Response: queryResponse(newLLMResponse(ctx, response.Response, collector)),
+		Trace:    ragTrace(response.Trace),
+	}, nil
+}
+
+func (grpcServer) SQLQuery(ctx context.Context, in *orchestratorv1.SQLQueryRequest) (*orchestratorv1.QueryResponse, error) {
+	request, err := sqlQueryRequest(in)
+	if err == nil {
+		err = validation.LLMSQLQueryRequest(ctx, &request)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, collector := llm.WithCollector(ctx)
+	response, err := llm.QueryUserRequestAsSQL(ctx, request.Model, request.Input, llm.ResolveOptions(llm.StageSQL, request.Options))
+	if err != nil {
+		return nil, err
+	}
+	return queryResponse(newLLMResponse(ctx, response, collector)), nil
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
+	orchestratorv1 "orchestrator/pkg/proto/orchestrator/v1"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// The conversions below turn gRPC requests into the request types of the REST
+// API, so that both are validated and answered by the same code
+
+func rowEmbeddingsRequest(in *orchestratorv1.RowEmbeddingsRequest) (models.RowEmbeddingsRequest, error) {
+	key, err := jsonValue("row_primary_key", in.GetRowPrimaryKey())
+	return models.RowEmbeddingsRequest{Table: in.GetTable(), RowPrimaryKey: key, Model: in.GetModel()}, err
+}
+
+func documentEmbeddingsRequest(in *orchestratorv1.DocumentEmbeddingsRequest) models.DocumentEmbeddingsRequest {
+	return models.DocumentEmbeddingsRequest{CID: in.GetCid(), CollectionSlug: in.GetCollectionSlug(), Model: in.GetModel()}
+}
+
+func simpleQueryRequest(in *orchestratorv1.ChatRequest) (models.LLMSimpleQueryRequest, error) {
+	options, err := generationOptions("options", in.GetOptions())
+	return models.LLMSimpleQueryRequest{Input: in.GetInput(), Model: in.GetModel(), ConversationID: in.GetConversationId(), Options: options}, err
+}
+
+func sqlQueryRequest(in *orchestratorv1.SQLQueryRequest) (models.LLMSQLQueryRequest, error) {
+	options, err := generationOptions("options", in.GetOptions())
+	return models.LLMSQLQueryRequest{Input: in.GetInput(), Model: in.GetModel(), ConversationID: in.GetConversationId(), Options: options}, err
+}
+
+func ragQueryRequest(in *orchestratorv1.RAGQueryRequest) (models.LLMRAGQueryRequest, error) {
+	request := models.LLMRAGQueryRequest{
+		Input:           in.GetInput(),
+		Model:           in.GetModel(),
+		EmbeddingModel:  in.GetEmbeddingModel(),
+		SearchLimit:     int(in.GetSearchLimit()),
+		DataSources:     in.GetDataSources(),
+		ConversationID:  in.GetConversationId(),
+		MinSubQuestions: int(in.GetMinSubQuestions()),
+		MaxSubQuestions: int(in.GetMaxSubQuestions()),
+		Debug:           in.GetDebug(),
+	}
+	var err error
+	if request.Options, err = generationOptions("options", in.GetOptions()); err != nil {
+		return request, err
+	}
+	if len(in.GetStageOptions()) > 0 {
+		request.StageOptions = make(map[string]models.GenerationOptions, len(in.GetStageOptions()))
+		for stage, stageOptions := range in.GetStageOptions() {
+			options, err := generationOptions("stage_options."+stage, stageOptions)
+			if err != nil {
+				return request, err
+			}
+			if options != nil {
+				request.StageOptions[stage] = *options
+			}
+		}
+	}
+	return request, nil
+}
+
+func generationOptions(field string, in *orchestratorv1.GenerationOptions) (*models.GenerationOptions, error) {
+	if in == nil {
+		return nil, nil
+	}
+	format, err := jsonValue(field+".format", in.GetFormat())
+	options := &models.GenerationOptions{
+		Temperature: in.Temperature,
+		TopP:        in.TopP,
+		NumCtx:      intPointer(in.NumCtx),
+		Seed:        intPointer(in.Seed),
+		Stop:        in.GetStop(),
+		Format:      format,
+	}
+	return options, err
+}
+
+func intPointer(value *int32) *int {
+	if value == nil {
+		return nil
+	}
+	converted := int(*value)
+	return &converted
+}
+
+// jsonValue encodes a google.protobuf.Value as the JSON the REST API would have received
+func jsonValue(field string, value *structpb.Value) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := protojson.Marshal(value)
+	if err != nil {
+		return nil, &validation.Error{Fields: []models.FieldError{{Field: field, Message: fmt.Sprintf("is not valid JSON: %v", err)}}}
+	}
+	return encoded, nil
+}
+
+func queryResponse(response models.LLMResponse) *orchestratorv1.QueryResponse {
+	out := &orchestratorv1.QueryResponse{Response: response.Response, PromptVersions: response.PromptVersions}
+	for _, report := range response.Trimmed {
+		out.Trimmed = append(out.Trimmed, &orchestratorv1.PromptReport{
+			Stage:                  report.Stage,
+			ContextWindow:          int32(report.ContextWindow),
+			EstimatedTokens:        int32(report.EstimatedTokens),
+			DroppedContext:         report.DroppedContext,
+			DroppedHistoryMessages: int32(report.DroppedHistoryMessages),
+		})
+	}
+	if response.Usage != nil {
+		out.Usage = &orchestratorv1.UsageReport{Total: usage(response.Usage.Total)}
+		for _, stage := range response.Usage.Stages {
+			out.Usage.Stages = append(out.Usage.Stages, &orchestratorv1.StageUsage{Stage: stage.Stage, Model: stage.Model, Usage: usage(stage.Usage)})
+		}
+	}
+	return out
+}
+
+func usage(in models.Usage) *orchestratorv1.Usage {
+	return &orchestratorv1.Usage{
+		Calls:                int32(in.Calls),
+		PromptTokens:         int32(in.PromptTokens),
+		CompletionTokens:     int32(in.CompletionTokens),
+		TotalTokens:          int32(in.TotalTokens),
+		TotalDurationMs:      in.TotalDurationMs,
+		LoadDurationMs:       in.LoadDurationMs,
+		PromptEvalDurationMs: in.PromptEvalDurationMs,
+		EvalDurationMs:       in.EvalDurationMs,
+	}
+}
+
+func ragTrace(in *models.RAGTrace) *orchestratorv1.RAGTrace {
+	if in == nil {
+		return nil
+	}
+	out := &orchestratorv1.RAGTrace{FallbackReason: in.FallbackReason}
+	for _, result := range in.SubResults {
+		subResult := &orchestratorv1.SubResult{
+			StepId:     result.StepID,
+			Question:   result.Question,
+			DataSource: result.DataSource,
+			DependsOn:  result.DependsOn,
+			Success:    result.Success,
+			Answer:     result.Answer,
+			Error:      result.Error,
+		}
+		for _, source := range result.Sources {
+			subResult.Sources = append(subResult.Sources, &orchestratorv1.Source{Type: source.Type, Reference: source.Reference, CollectionSlug: source.CollectionSlug})
+		}
+		out.SubResults = append(out.SubResults, subResult)
+	}
+	return out
+}
@@ -1,11 +1,14 @@
 package api
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
 	"orchestrator/internal/llm"
 	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,133 +17,122 @@ func handlePing(c *gin.Context) {
 	c.String(http.StatusOK, "pong! orchestrator is at your command")
 }
 
-func handleUserProfile(db map[string]string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user := c.Param("name")
-		value, ok := db[user]
-		if ok {
-			c.JSON(http.StatusOK, gin.H{"user": user, "value": value})
-		} else {
-			c.JSON(http.StatusOK, gin.H{"user": user, "status": "no value"})
-		}
-	}
-}
-
-func handleAdminEndpoint(db map[string]string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user := c.MustGet(gin.AuthUserKey).(string)
-		var json struct {
-			Value string `json:"value" binding:"required"`
-		}
-
-		if c.Bind(&json) == nil {
-			db[user] = json.Value
-			c.JSON(http.StatusOK, gin.H{"status": "ok"})
-		}
-	}
+// handleGetConfig shows the loaded configuration without its secrets
+func handleGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get().Redacted())
 }
 
 func handleGenerateRowEmbeddings(c *gin.Context) {
-	user := c.MustGet(gin.AuthUserKey).(string)
-	if user != "foo" {
-		c.JSON(http.StatusOK, gin.H{"status": "error"})
-		return
-	}
-
 	var request models.RowEmbeddingsRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &request, validation.RowEmbeddingsRequest) {
 		return
 	}
 
-	go llm.ProcessRowEmbeddings(request)
+	ctx, finished := startJob(c.Request.Context(), "row_embeddings")
+	go func() {
+		err := llm.ProcessRowEmbeddings(ctx, request)
+		finished(err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error processing row embeddings", "table", request.Table, "error", err)
+		}
+	}()
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Processing started"})
+	c.JSON(http.StatusOK, models.StatusResponse{Status: "ok", Message: "Processing started"})
 }
 
 func handleGenerateDocumentEmbeddings(c *gin.Context) {
-	fmt.Printf("handleGenerateDocumentEmbeddings\n")
-	user := c.MustGet(gin.AuthUserKey).(string)
-	if user != "foo" {
-		c.JSON(http.StatusUnauthorized, gin.H{"status": "error", "message": "Unauthorized"})
-		return
-	}
-
 	var request models.DocumentEmbeddingsRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": err.Error()})
+	if !bindRequest(c, &request, validation.DocumentEmbeddingsRequest) {
 		return
 	}
 
+	ctx, finished := startJob(c.Request.Context(), "document_embeddings")
 	go func() {
-		err := llm.ProcessDocumentEmbeddingsInChunks(request)
+		err := llm.ProcessDocumentEmbeddingsInChunks(ctx, request)
+		finished(err)
 		if err != nil {
-			log.Printf("Error processing document embeddings: %v", err)
+			slog.ErrorContext(ctx, "Error processing document embeddings", "cid", request.CID, "error", err)
 		}
 	}()
 
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Processing started"})
+	c.JSON(http.StatusOK, models.StatusResponse{Status: "ok", Message: "Processing started"})
 }
 
 func handleLLMSimpleQuery(c *gin.Context) {
 	var request models.LLMSimpleQueryRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &request, validation.LLMSimpleQueryRequest) {
 		return
 	}
 
-	response, err := llm.ProcessLLMSimpleQuery(request)
+	ctx, collector := llm.WithCollector(c.Request.Context())
+	response, err := llm.ProcessLLMSimpleQuery(ctx, request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": response})
+	c.JSON(http.StatusOK, newLLMResponse(ctx, response, collector))
 }
 
 func handleLLMSQLQuery(c *gin.Context) {
 	var request models.LLMSQLQueryRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &request, validation.LLMSQLQueryRequest) {
 		return
 	}
-	response, err := llm.QueryUserRequestAsSQL(request.Model, request.Input)
+	ctx, collector := llm.WithCollector(c.Request.Context())
+	response, err := llm.QueryUserRequestAsSQL(ctx, request.Model, request.Input, llm.ResolveOptions(llm.StageSQL, request.Options))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": response})
+	c.JSON(http.StatusOK, newLLMResponse(ctx, response, collector))
 }
 
 func handleLLMRAGQuerySingleNode(c *gin.Context) {
 	var request models.LLMRAGQueryRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &request, validation.LLMRAGQueryRequest) {
 		return
 	}
 
-	response, err := llm.ProcessLLMRAGQuerySingleNode(request)
+	ctx, collector := llm.WithCollector(c.Request.Context())
+	response, err := llm.ProcessLLMRAGQuerySingleNode(ctx, request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": response})
+	c.JSON(http.StatusOK, newLLMResponse(ctx, response, collector))
 }
 
 func handleLLMRAGQueryMultiNode(c *gin.Context) {
 	var request models.LLMRAGQueryRequest
-	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if !bindRequest(c, &request, validation.LLMRAGQueryRequest) {
 		return
 	}
 
-	response, err := llm.ProcessLLMRAGQueryMultiNode(request)
+	ctx, collector := llm.WithCollector(c.Request.Context())
+	response, err := llm.ProcessLLMRAGQueryMultiNode(ctx, request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		abortWithError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"response": response})
+	response.LLMResponse = newLLMResponse(ctx, response.Response, collector)
+	publicTrace(response.Trace)
+	c.JSON(http.StatusOK, response)
+}
+
+// newLLMResponse reports what the collector gathered and records the
+// request's model usage against the authenticated user
+func newLLMResponse(ctx context.Context, response string, collector *llm.Collector) models.LLMResponse {
+	usage := collector.Usage()
+	identity, _ := auth.FromContext(ctx)
+	llm.RecordUsage(identity.Subject, usage)
+	return models.LLMResponse{
+		Response:       response,
+		Trimmed:        collector.Trimmed(),
+		PromptVersions: collector.PromptVersions(),
+		Usage:          usage,
+	}
 }
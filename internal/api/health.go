@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"orchestrator/internal/config"
+	"orchestrator/internal/database"
+	"orchestrator/internal/fileprocessing"
+	"orchestrator/internal/llm"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const readinessCheckTimeout = 3 * time.Second
+
+// readinessChecks are the dependencies a request may need
+var readinessChecks = map[string]func(context.Context) error{
+	"postgres": database.CheckReady,
+	"ollama": func(ctx context.Context) error {
+		defaults := config.Get().Defaults
+		return llm.CheckModels(ctx, defaults.ChatModel, defaults.EmbeddingModel)
+	},
+	"ipfs": fileprocessing.CheckIPFS,
+}
+
+// handleHealthz tells the orchestrator is alive, without looking at its dependencies
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz checks every dependency in parallel and fails once shutdown
+// has begun. Failures are logged rather than returned, since the endpoint is public
+func handleReadyz(c *gin.Context) {
+	if draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checks := make(map[string]string, len(readinessChecks))
+	ready := true
+	for name, check := range readinessChecks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			checks[name] = "ok"
+			if err != nil {
+				slog.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+				checks[name] = "failing"
+				ready = false
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
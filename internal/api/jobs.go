@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"orchestrator/internal/metrics"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// jobTracker keeps the background jobs that are running, so that shutdown can
+// wait for them and cancel the ones still running at its deadline
+type jobTracker struct {
+	mu      sync.Mutex
+	next    int
+	cancels map[int]context.CancelFunc
+	// Closed and replaced whenever a job finishes
+	finished chan struct{}
+}
+
+var jobs = jobTracker{cancels: make(map[int]context.CancelFunc), finished: make(chan struct{})}
+
+// draining is set once shutdown has begun
+var draining atomic.Bool
+
+// startJob marks a background job as running. The job outlives the request,
+// so its context keeps the request's values but is only cancelled by a
+// shutdown that runs out of time. Call the returned function with the job's
+// result when it is done
+func startJob(ctx context.Context, job string) (context.Context, func(error)) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	jobs.mu.Lock()
+	id := jobs.next
+	jobs.next++
+	jobs.cancels[id] = cancel
+	jobs.mu.Unlock()
+
+	metrics.JobsInProgress.WithLabelValues(job).Inc()
+	return ctx, func(err error) {
+		cancel()
+		metrics.JobsInProgress.WithLabelValues(job).Dec()
+		metrics.Jobs.WithLabelValues(job, metrics.Outcome(err)).Inc()
+
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		delete(jobs.cancels, id)
+		close(jobs.finished)
+		jobs.finished = make(chan struct{})
+	}
+}
+
+// wait returns once no jobs are running, or cancels the remaining jobs when ctx is done
+func (t *jobTracker) wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		running, finished := len(t.cancels), t.finished
+		t.mu.Unlock()
+		if running == 0 {
+			return nil
+		}
+
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			for _, cancel := range t.cancels {
+				cancel()
+			}
+			return fmt.Errorf("cancelled %d background jobs: %w", len(t.cancels), ctx.Err())
+		}
+	}
+}
+
+// Drain shuts the servers down gracefully: /readyz starts failing, the
+// servers stop accepting connections and finish the requests and calls in
+// flight, and then the background jobs and the answers on chat WebSockets
+// are given until ctx is done to complete. grpcServer may be nil
+func Drain(ctx context.Context, server *http.Server, grpcServer *grpc.Server) error {
+	draining.Store(true)
+	slog.Info("Shutting down, no longer accepting requests")
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("error finishing requests in flight: %w", err))
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		if grpcServer != nil {
+			// Cancels the calls still running
+			grpcServer.Stop()
+			errs = append(errs, fmt.Errorf("error finishing gRPC calls in flight: %w", ctx.Err()))
+		}
+	}
+
+	jobs.mu.Lock()
+	running := len(jobs.cancels)
+	jobs.mu.Unlock()
+	if running > 0 {
+		slog.Info("Waiting for background jobs", "jobs", running)
+	}
+	// Once ctx is done, this cancels the jobs still running
+	if err := jobs.wait(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	closeSockets()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"orchestrator/internal/logging"
+	"orchestrator/internal/metrics"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMiddleware tags the request context with the caller's X-Request-ID,
+// or a new ID if it sent none, and echoes it in the response
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := logging.RequestIDOrNew(c.GetHeader(requestIDHeader))
+		c.Header(requestIDHeader, requestID)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", requestID))
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// accessLogMiddleware logs every request once it has been handled
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "Handled request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"user", c.GetString(gin.AuthUserKey),
+		)
+	}
+}
+
+// recoveryMiddleware turns panics into 500 responses and logs them with the request ID
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		slog.ErrorContext(c.Request.Context(), "Recovered from panic", "error", recovered, "stack", string(debug.Stack()))
+		abortWithError(c, fmt.Errorf("panic: %v", recovered))
+	})
+}
+
+// metricsMiddleware counts requests and measures their latency per route
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// Label by route pattern rather than path to keep the number of series bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/models"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const openAPIVersion = "1.0.0"
+
+// openAPISpec describes the routes as an OpenAPI 3 document. Request and
+// response schemas are derived from the Go types the handlers bind and
+// return, so the spec cannot drift from the code
+func openAPISpec(routes []route) map[string]any {
+	schemas := schemaBuilder{components: make(map[string]any)}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content":     map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(models.ErrorResponse{}))}},
+	}
+
+	paths := make(map[string]map[string]any)
+	for _, r := range routes {
+		operation := map[string]any{
+			"summary":     r.summary,
+			"operationId": operationID(r),
+		}
+
+		var parameters []any
+		for _, segment := range strings.Split(r.path, "/") {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				parameters = append(parameters, map[string]any{"name": name, "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+			}
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		if r.request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemas.of(reflect.TypeOf(r.request))}},
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(r.status)}
+		if r.response != nil {
+			contentType := r.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			success["content"] = map[string]any{contentType: map[string]any{"schema": schemas.of(reflect.TypeOf(r.response))}}
+		}
+		responses := map[string]any{strconv.Itoa(r.status): success}
+		if r.request != nil || parameters != nil {
+			responses["400"] = errorResponse
+		}
+		if r.role != "" {
+			operation["description"] = "Requires the " + string(r.role) + " role."
+			if r.role == roleAuthenticated {
+				operation["description"] = "Requires credentials with any role."
+			}
+			operation["security"] = []any{map[string]any{"bearerAuth": []any{}}, map[string]any{"apiKey": []any{}}}
+			responses["401"] = errorResponse
+			responses["403"] = errorResponse
+			responses["500"] = errorResponse
+		}
+		if r.role == auth.RoleQuery {
+			responses["429"] = errorResponse
+		}
+		operation["responses"] = responses
+		addOperation(paths, r.method, r.path, operation)
+
+		if r.legacyPath != "" {
+			legacy := maps.Clone(operation)
+			legacy["operationId"] = operationID(route{method: r.method, path: r.legacyPath})
+			description := "Deprecated alias of " + r.method + " " + openAPIPath(r.path) + "."
+			if roleDescription, ok := operation["description"].(string); ok {
+				description += " " + roleDescription
+			}
+			legacy["description"] = description
+			legacy["deprecated"] = true
+			addOperation(paths, r.method, r.legacyPath, legacy)
+		}
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Orchestrator API",
+			"description": "Retrieval-augmented LLM queries over GameFi data and documents",
+			"version":     openAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer", "description": "API key or JWT"},
+				"apiKey":     map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// openAPIPath turns gin parameters (:id) into OpenAPI ones ({id})
+func openAPIPath(ginPath string) string {
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// addOperation adds the operation under the OpenAPI form of the route path
+func addOperation(paths map[string]map[string]any, method string, routePath string, operation map[string]any) {
+	openAPIPath := openAPIPath(routePath)
+	if paths[openAPIPath] == nil {
+		paths[openAPIPath] = make(map[string]any)
+	}
+	paths[openAPIPath][strings.ToLower(method)] = operation
+}
+
+// operationID names an operation after its method and path, e.g. postV1QueryRagMulti
+func operationID(r route) string {
+	id := strings.ToLower(r.method)
+	for _, word := range strings.FieldsFunc(r.path, func(c rune) bool { return c == '/' || c == ':' || c == '.' || c == '_' }) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemaBuilder derives JSON schemas from Go types the way encoding/json
+// marshals them. Named structs become shared components
+type schemaBuilder struct {
+	components map[string]any
+}
+
+func (b *schemaBuilder) of(t reflect.Type) map[string]any {
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]any{}
+	case t.Implements(textMarshalerType):
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.of(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := b.components[name]; !ok {
+			// Reserve the name first so that recursive types terminate
+			b.components[name] = nil
+			b.components[name] = b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object describes a struct, inlining the fields of embedded structs
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	b.addFields(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.of(field.Type)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaName names models types plainly and qualifies the others with their package
+func schemaName(t reflect.Type) string {
+	if t.PkgPath() == reflect.TypeOf(models.ErrorResponse{}).PkgPath() {
+		return t.Name()
+	}
+	pkg := path.Base(t.PkgPath())
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
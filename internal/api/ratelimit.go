@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitMiddleware applies the caller's rate limit and concurrency quota
+// on the route and reports what is left of them in X-RateLimit-* headers
+func rateLimitMiddleware(limiter *ratelimit.Limiter, route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, _ := auth.FromContext(c.Request.Context())
+		decision, release, err := limiter.Acquire(callerID(identity), identity.Subject, route)
+		setQuotaHeaders(c, decision)
+		if err != nil {
+			reason := "rate"
+			if errors.Is(err, ratelimit.ErrConcurrencyLimited) {
+				reason = "concurrency"
+			}
+			metrics.RateLimited.WithLabelValues(route, reason).Inc()
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			abortWithError(c, err)
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// callerID identifies whose quota a request counts against
+func callerID(identity auth.Identity) string {
+	if identity.KeyID != 0 {
+		return "api_key:" + strconv.FormatInt(identity.KeyID, 10)
+	}
+	return identity.Method + ":" + identity.Subject
+}
+
+func setQuotaHeaders(c *gin.Context, decision ratelimit.Decision) {
+	if decision.Limit.RequestsPerMinute > 0 {
+		c.Header("X-RateLimit-Limit", strconv.FormatFloat(decision.Limit.RequestsPerMinute, 'f', -1, 64))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	}
+	if decision.Limit.MaxConcurrent > 0 {
+		c.Header("X-Concurrency-Limit", strconv.Itoa(decision.Limit.MaxConcurrent))
+		c.Header("X-Concurrency-Remaining", strconv.Itoa(decision.ConcurrentRemaining))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func handleGetRateLimits(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, limiter.Config())
+	}
+}
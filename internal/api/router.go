@@ -1,30 +1,129 @@
 package api
 
 import (
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/models"
+	"orchestrator/internal/ratelimit"
+	"orchestrator/internal/tracing"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func SetupRouter() *gin.Engine {
-	router := gin.Default()
+// route describes an endpoint both to gin and to the OpenAPI spec
+type route struct {
+	method  string
+	path    string
+	summary string
+	// Path served before the /v1 routes, kept as a deprecated alias
+	legacyPath string
+	// Role needed to call the route; public routes have none. Query routes are rate limited
+	role auth.Role
+	// Set for WebSocket routes, which rate limit each message rather than the upgrade
+	socket bool
+	// Zero values of the JSON request and response bodies; nil if there is none
+	request  any
+	response any
+	// Status of a successful response
+	status int
+	// Set for responses that are not JSON
+	contentType string
+	handler     gin.HandlerFunc
+}
+
+// roleAuthenticated is the role of routes open to any credentials
+const roleAuthenticated auth.Role = "authenticated"
 
-	db := make(map[string]string)
+// routes lists every endpoint of the orchestrator
+func routes(limiter *ratelimit.Limiter) []route {
+	return []route{
+		{method: http.MethodGet, path: "/ping", summary: "Check that the orchestrator is up", response: "", status: http.StatusOK, contentType: "text/plain", handler: handlePing},
+		{method: http.MethodGet, path: "/healthz", summary: "Liveness probe", response: gin.H{}, status: http.StatusOK, handler: handleHealthz},
+		{method: http.MethodGet, path: "/readyz", summary: "Readiness probe checking Postgres, pgvector, Ollama and IPFS", response: gin.H{}, status: http.StatusOK, handler: handleReadyz},
+		{method: http.MethodGet, path: "/metrics", summary: "Prometheus metrics", response: "", status: http.StatusOK, contentType: "text/plain", handler: gin.WrapH(metrics.Handler())},
 
-	router.GET("/ping", handlePing)
-	router.GET("/user/:name", handleUserProfile(db))
+		{method: http.MethodGet, path: "/v1/me", legacyPath: "/me", summary: "Get the user behind the caller's credentials", role: roleAuthenticated, response: models.User{}, status: http.StatusOK, handler: handleGetCurrentUser},
+		{method: http.MethodPut, path: "/v1/me/preferences", legacyPath: "/me/preferences", summary: "Replace the request defaults of the caller's user", role: roleAuthenticated, request: models.UserPreferences{}, response: models.User{}, status: http.StatusOK, handler: handleSetPreferences},
 
-	//TODO: Add authentication
-	authorized := router.Group("/", gin.BasicAuth(gin.Accounts{
-		"foo":  "bar",
-		"manu": "123",
-	}))
+		{method: http.MethodPost, path: "/v1/embeddings/rows", legacyPath: "/generateRowEmbeddings", summary: "Embed a table row in the background", role: auth.RoleIngest, request: models.RowEmbeddingsRequest{}, response: models.StatusResponse{}, status: http.StatusOK, handler: handleGenerateRowEmbeddings},
+		{method: http.MethodPost, path: "/v1/embeddings/documents", legacyPath: "/generateDocumentEmbeddings", summary: "Embed a document from IPFS in the background", role: auth.RoleIngest, request: models.DocumentEmbeddingsRequest{}, response: models.StatusResponse{}, status: http.StatusOK, handler: handleGenerateDocumentEmbeddings},
+		{method: http.MethodGet, path: "/v1/shares", legacyPath: "/shares", summary: "List the collections shared by and with the caller's tenant", role: auth.RoleIngest, response: []models.CorpusShare{}, status: http.StatusOK, handler: handleListShares},
+		{method: http.MethodPost, path: "/v1/shares", legacyPath: "/shares", summary: "Share a collection with another tenant", role: auth.RoleIngest, request: models.CorpusShareRequest{}, response: models.CorpusShare{}, status: http.StatusCreated, handler: handleShareCollection},
+		{method: http.MethodDelete, path: "/v1/shares", legacyPath: "/shares", summary: "Stop sharing a collection", role: auth.RoleIngest, request: models.CorpusShareRequest{}, status: http.StatusNoContent, handler: handleUnshareCollection},
+
+		{method: http.MethodPost, path: "/v1/query/chat", legacyPath: "/llm/simple", summary: "Chat with a model, optionally within a conversation", role: auth.RoleQuery, request: models.LLMSimpleQueryRequest{}, response: models.LLMResponse{}, status: http.StatusOK, handler: handleLLMSimpleQuery},
+		{method: http.MethodGet, path: chatSocketPath, summary: "Chat within a conversation over a WebSocket, with the answers streamed as tokens and stage events", role: auth.RoleQuery, socket: true, status: http.StatusSwitchingProtocols, handler: handleChatSocket(limiter)},
+		{method: http.MethodPost, path: "/v1/query/rag", legacyPath: "/llm/rag/single", summary: "Answer from documents and rows retrieved for the input", role: auth.RoleQuery, request: models.LLMRAGQueryRequest{}, response: models.LLMResponse{}, status: http.StatusOK, handler: handleLLMRAGQuerySingleNode},
+		{method: http.MethodPost, path: "/v1/query/rag/multi", legacyPath: "/llm/rag/multi", summary: "Answer by planning and answering sub-questions against several data sources", role: auth.RoleQuery, request: models.LLMRAGQueryRequest{}, response: models.LLMRAGQueryResponse{}, status: http.StatusOK, handler: handleLLMRAGQueryMultiNode},
+		{method: http.MethodPost, path: "/v1/query/sql", legacyPath: "/llm/sql", summary: "Answer by generating and running a SQL query", role: auth.RoleQuery, request: models.LLMSQLQueryRequest{}, response: models.LLMResponse{}, status: http.StatusOK, handler: handleLLMSQLQuery},
+
+		{method: http.MethodPost, path: "/v1/admin/keys", legacyPath: "/admin/keys", summary: "Create an API key", role: auth.RoleAdmin, request: models.CreateAPIKeyRequest{}, response: models.CreateAPIKeyResponse{}, status: http.StatusCreated, handler: handleCreateAPIKey},
+		{method: http.MethodGet, path: "/v1/admin/keys", legacyPath: "/admin/keys", summary: "List API keys", role: auth.RoleAdmin, response: []models.APIKeyInfo{}, status: http.StatusOK, handler: handleListAPIKeys},
+		{method: http.MethodDelete, path: "/v1/admin/keys/:id", legacyPath: "/admin/keys/:id", summary: "Revoke an API key", role: auth.RoleAdmin, status: http.StatusNoContent, handler: handleRevokeAPIKey},
+		{method: http.MethodPost, path: "/v1/admin/users", legacyPath: "/admin/users", summary: "Create a user", role: auth.RoleAdmin, request: models.CreateUserRequest{}, response: models.User{}, status: http.StatusCreated, handler: handleCreateUser},
+		{method: http.MethodGet, path: "/v1/admin/users", legacyPath: "/admin/users", summary: "List users", role: auth.RoleAdmin, response: []models.User{}, status: http.StatusOK, handler: handleListUsers},
+		{method: http.MethodGet, path: "/v1/admin/users/:id", legacyPath: "/admin/users/:id", summary: "Get a user", role: auth.RoleAdmin, response: models.User{}, status: http.StatusOK, handler: handleGetUser},
+		{method: http.MethodPatch, path: "/v1/admin/users/:id", legacyPath: "/admin/users/:id", summary: "Update the profile or preferences of a user", role: auth.RoleAdmin, request: models.UpdateUserRequest{}, response: models.User{}, status: http.StatusOK, handler: handleUpdateUser},
+		{method: http.MethodDelete, path: "/v1/admin/users/:id", legacyPath: "/admin/users/:id", summary: "Delete a user and revoke their API keys", role: auth.RoleAdmin, status: http.StatusNoContent, handler: handleDeleteUser},
+		{method: http.MethodGet, path: "/v1/admin/limits", legacyPath: "/admin/limits", summary: "Show the rate limits in effect", role: auth.RoleAdmin, response: ratelimit.Config{}, status: http.StatusOK, handler: handleGetRateLimits(limiter)},
+		{method: http.MethodGet, path: "/v1/admin/config", legacyPath: "/admin/config", summary: "Show the configuration with secrets redacted", role: auth.RoleAdmin, response: config.Config{}, status: http.StatusOK, handler: handleGetConfig},
+	}
+}
 
-	authorized.POST("admin", handleAdminEndpoint(db))
-	authorized.POST("generateRowEmbeddings", handleGenerateRowEmbeddings)
-	authorized.POST("generateDocumentEmbeddings", handleGenerateDocumentEmbeddings)
-	authorized.POST("/llm/simple", handleLLMSimpleQuery)
-	authorized.POST("/llm/rag/single", handleLLMRAGQuerySingleNode)
-	authorized.POST("/llm/rag/multi", handleLLMRAGQueryMultiNode)
-	authorized.POST("/llm/sql", handleLLMSQLQuery)
+func SetupRouter(authenticator *auth.Authenticator, limiter *ratelimit.Limiter) *gin.Engine {
+	router := gin.New()
+	// Scrapes and probes would otherwise drown out the traces worth looking at
+	untracedPaths := otelgin.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/metrics" && r.URL.Path != "/healthz" && r.URL.Path != "/readyz"
+	})
+	router.Use(otelgin.Middleware(tracing.ServiceName, untracedPaths), requestIDMiddleware(), accessLogMiddleware(), recoveryMiddleware(), metricsMiddleware())
 
+	router.NoRoute(handleNoRoute)
+
+	settings := config.Get()
+	var spec map[string]any
+	all := append(routes(limiter), route{
+		method: http.MethodGet, path: "/v1/openapi.json", legacyPath: "/openapi.json", summary: "This OpenAPI specification", response: gin.H{}, status: http.StatusOK,
+		handler: func(c *gin.Context) { c.JSON(http.StatusOK, spec) },
+	})
+	if !settings.Server.LegacyRoutes {
+		for i := range all {
+			all[i].legacyPath = ""
+		}
+	}
+	spec = openAPISpec(all)
+
+	router.Use(securityMiddleware(settings.HTTP, all)...)
+
+	for _, r := range all {
+		handlers := append(middleware(r, authenticator, limiter), r.handler)
+		router.Handle(r.method, r.path, handlers...)
+		if r.legacyPath != "" {
+			router.Handle(r.method, r.legacyPath, append([]gin.HandlerFunc{deprecationMiddleware(r.path, time.Time(settings.Server.LegacySunset))}, handlers...)...)
+		}
+	}
 	return router
 }
+
+// middleware returns the handlers that run before those of the route: the
+// credential and role checks, and rate limiting of query routes
+func middleware(r route, authenticator *auth.Authenticator, limiter *ratelimit.Limiter) []gin.HandlerFunc {
+	switch r.role {
+	case "":
+		return nil
+	case roleAuthenticated:
+		return []gin.HandlerFunc{authMiddleware(authenticator)}
+	case auth.RoleQuery:
+		if r.socket {
+			return []gin.HandlerFunc{authMiddleware(authenticator), requireRole(r.role)}
+		}
+		// Limited by the /v1 path so that legacy aliases share its quota
+		return []gin.HandlerFunc{authMiddleware(authenticator), requireRole(r.role), rateLimitMiddleware(limiter, r.path)}
+	default:
+		return []gin.HandlerFunc{authMiddleware(authenticator), requireRole(r.role)}
+	}
+}
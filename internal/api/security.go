@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exposedHeaders are the response headers browsers let scripts of other origins read
+var exposedHeaders = []string{
+	requestIDHeader, "Retry-After",
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-Concurrency-Limit", "X-Concurrency-Remaining",
+	"Deprecation", "Sunset", "Link",
+}
+
+// securityMiddleware returns the handlers that apply the http settings to
+// every request: security headers, CORS and the body size limits
+func securityMiddleware(settings config.HTTP, routes []route) []gin.HandlerFunc {
+	limits := make(map[string]int64)
+	for _, r := range routes {
+		if limit, ok := settings.RouteBodyBytes[r.path]; ok {
+			limits[r.path] = int64(limit)
+			if r.legacyPath != "" {
+				limits[r.legacyPath] = int64(limit)
+			}
+		}
+	}
+
+	handlers := []gin.HandlerFunc{corsMiddleware(settings.CORS), bodyLimitMiddleware(int64(settings.MaxBodyBytes), limits)}
+	if settings.SecurityHeaders {
+		handlers = append([]gin.HandlerFunc{securityHeadersMiddleware(time.Duration(settings.HSTSMaxAge))}, handlers...)
+	}
+	return handlers
+}
+
+// ValidateConfig rejects body limits of routes that do not exist
+func ValidateConfig(settings config.HTTP) error {
+	for path := range settings.RouteBodyBytes {
+		if !slices.ContainsFunc(routes(nil), func(r route) bool { return r.path == path }) {
+			return fmt.Errorf("http.route_body_bytes: unknown route %q", path)
+		}
+	}
+	return nil
+}
+
+// securityHeadersMiddleware sets the headers that keep browsers from
+// sniffing, framing or caching API responses
+func securityHeadersMiddleware(hstsMaxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		// Responses may hold API keys and answers over private documents
+		header.Set("Cache-Control", "no-store")
+		if hstsMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hstsMaxAge.Seconds())))
+		}
+		c.Next()
+	}
+}
+
+// corsMiddleware lets browsers on the allowed origins call the API and
+// answers their preflight requests
+func corsMiddleware(cors config.CORS) gin.HandlerFunc {
+	anyOrigin := slices.Contains(cors.AllowedOrigins, "*")
+	methods := strings.Join([]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}, ", ")
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || len(cors.AllowedOrigins) == 0 {
+			c.Next()
+			return
+		}
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !originAllowed(cors, origin) {
+			if preflight {
+				abortWithError(c, fmt.Errorf("%w: origin %s is not allowed", auth.ErrForbidden, origin))
+				return
+			}
+			// Without the CORS headers the browser keeps the response from the page
+			c.Next()
+			return
+		}
+
+		if anyOrigin && !cors.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			header.Set("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", methods)
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+		if maxAge := time.Duration(cors.MaxAge); maxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// originAllowed reports whether pages of the origin may call the API
+func originAllowed(cors config.CORS, origin string) bool {
+	return slices.ContainsFunc(cors.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(allowed, origin)
+	})
+}
+
+// bodyLimitMiddleware rejects bodies larger than the route's limit with 413,
+// up front when Content-Length gives them away and otherwise while they are read
+func bodyLimitMiddleware(defaultLimit int64, routeLimits map[string]int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := defaultLimit
+		if routeLimit, ok := routeLimits[c.FullPath()]; ok {
+			limit = routeLimit
+		}
+		if c.Request.ContentLength > limit {
+			abortWithError(c, &http.MaxBytesError{Limit: limit})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+func handleShareCollection(c *gin.Context) {
+	var request models.CorpusShareRequest
+	if !bindRequest(c, &request, validation.CorpusShareRequest) {
+		return
+	}
+	tenant, err := auth.TenantFromContext(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	share, err := auth.ShareCollection(c.Request.Context(), tenant, request.CollectionSlug, request.SharedWith)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, share)
+}
+
+func handleUnshareCollection(c *gin.Context) {
+	var request models.CorpusShareRequest
+	if !bindRequest(c, &request, bindOnly) {
+		return
+	}
+	tenant, err := auth.TenantFromContext(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	err = auth.UnshareCollection(c.Request.Context(), tenant, request.CollectionSlug, request.SharedWith)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleListShares(c *gin.Context) {
+	tenant, err := auth.TenantFromContext(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	shares, err := auth.ListShares(c.Request.Context(), tenant)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, shares)
+}
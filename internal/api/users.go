@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+func handleCreateUser(c *gin.Context) {
+	var request models.CreateUserRequest
+	if !bindRequest(c, &request, validation.CreateUserRequest) {
+		return
+	}
+
+	user, err := auth.CreateUser(c.Request.Context(), request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+func handleListUsers(c *gin.Context) {
+	users, err := auth.ListUsers(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+func handleGetUser(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	user, err := auth.GetUser(c.Request.Context(), id)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func handleUpdateUser(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+	var request models.UpdateUserRequest
+	if !bindRequest(c, &request, validation.UpdateUserRequest) {
+		return
+	}
+
+	user, err := auth.UpdateUser(c.Request.Context(), id, request)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func handleDeleteUser(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	if err := auth.DeleteUser(c.Request.Context(), id); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleGetCurrentUser shows the user behind the caller's credentials
+func handleGetCurrentUser(c *gin.Context) {
+	user, err := auth.CurrentUser(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// handleSetPreferences replaces the preferences of the caller's user
+func handleSetPreferences(c *gin.Context) {
+	var preferences models.UserPreferences
+	if !bindRequest(c, &preferences, validation.UserPreferences) {
+		return
+	}
+	current, err := auth.CurrentUser(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := auth.UpdateUser(c.Request.Context(), current.ID, models.UpdateUserRequest{Preferences: &preferences})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
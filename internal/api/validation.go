@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"orchestrator/internal/models"
+	"orchestrator/internal/validation"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report binding failures by JSON field name rather than Go field name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindRequest decodes the JSON body into request and normalizes it. Invalid
+// requests are answered with 400 and the offending fields, and false is returned
+func bindRequest[T any](c *gin.Context, request *T, normalize func(context.Context, *T) error) bool {
+	err := c.ShouldBindJSON(request)
+	if err == nil {
+		err = normalize(c.Request.Context(), request)
+	}
+	if err == nil {
+		return true
+	}
+
+	abortWithError(c, invalidRequest(err))
+	return false
+}
+
+// invalidRequest turns binding failures into field errors
+func invalidRequest(err error) error {
+	var invalid *validation.Error
+	var missing validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &invalid), errors.As(err, &tooLarge):
+		return err
+	case errors.As(err, &missing):
+		fields := make([]models.FieldError, len(missing))
+		for i, fieldErr := range missing {
+			// The namespace starts with the Go name of the request type
+			_, path, _ := strings.Cut(fieldErr.Namespace(), ".")
+			fields[i] = models.FieldError{Field: path, Message: "is " + fieldErr.Tag()}
+		}
+		return &validation.Error{Fields: fields}
+	case errors.As(err, &typeErr):
+		return &validation.Error{Fields: []models.FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}}
+	default:
+		return &validation.Error{Fields: []models.FieldError{{Field: "body", Message: err.Error()}}}
+	}
+}
+
+// bindOnly is the normalizer of requests that need no more than their binding tags
+func bindOnly[T any](context.Context, *T) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+	"strings"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// API keys are "orc_" followed by 32 random bytes. They have enough entropy
+// that a fast hash is as safe to store as a password hash would be.
+const apiKeyPrefix = "orc_"
+
+// Keys shorter than this are rejected, including the bootstrap key
+const minAPIKeyLength = 32
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating API key: %w", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// displayPrefix is the part of a key that is safe to show in listings
+func displayPrefix(key string) string {
+	return key[:min(len(key), len(apiKeyPrefix)+6)]
+}
+
+// CreateAPIKey stores a new key, owned by the user unless userID is nil, and
+// returns it; the key cannot be retrieved again
+func CreateAPIKey(ctx context.Context, name string, tenant string, userID *int64, roles []Role) (string, database.APIKey, error) {
+	key, err := generateAPIKey()
+	if err != nil {
+		return "", database.APIKey{}, err
+	}
+	record, err := storeAPIKey(ctx, name, tenant, userID, key, roles)
+	return key, record, err
+}
+
+// Bootstrap stores key as an admin key of the default tenant named
+// "bootstrap" unless it is already known, so a fresh deployment can create
+// its first keys
+func Bootstrap(ctx context.Context, key string) error {
+	if len(key) < minAPIKeyLength {
+		return fmt.Errorf("bootstrap admin key must be at least %d characters", minAPIKeyLength)
+	}
+	db := database.Pool()
+
+	exists, err := database.APIKeyExists(ctx, db, HashAPIKey(key))
+	if err != nil {
+		return fmt.Errorf("error looking up bootstrap admin key: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	_, err = storeAPIKey(ctx, "bootstrap", DefaultTenant, nil, key, []Role{RoleAdmin})
+	return err
+}
+
+func storeAPIKey(ctx context.Context, name string, tenant string, userID *int64, key string, roles []Role) (database.APIKey, error) {
+	db := database.Pool()
+
+	record := database.APIKey{
+		Name:    name,
+		Tenant:  tenant,
+		Prefix:  displayPrefix(key),
+		KeyHash: HashAPIKey(key),
+		Roles:   roleNames(roles),
+		UserID:  userID,
+	}
+	if err := database.InsertAPIKey(ctx, db, &record); err != nil {
+		return database.APIKey{}, err
+	}
+	return record, nil
+}
+
+// ListAPIKeys returns every key, including revoked ones
+func ListAPIKeys(ctx context.Context) ([]models.APIKeyInfo, error) {
+	db := database.Pool()
+
+	records, err := database.ListAPIKeys(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]models.APIKeyInfo, len(records))
+	for i, record := range records {
+		keys[i] = APIKeyInfo(record)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey disables a key; it returns ErrKeyNotFound if there is no active key with the id
+func RevokeAPIKey(ctx context.Context, id int64) error {
+	err := database.RevokeAPIKey(ctx, database.Pool(), id)
+	if errors.Is(err, pg.ErrNoRows) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// APIKeyInfo describes a key without its hash
+func APIKeyInfo(record database.APIKey) models.APIKeyInfo {
+	return models.APIKeyInfo{
+		ID:         record.ID,
+		Name:       record.Name,
+		Tenant:     record.Tenant,
+		Prefix:     record.Prefix,
+		Roles:      record.Roles,
+		UserID:     record.UserID,
+		CreatedAt:  record.CreatedAt,
+		LastUsedAt: record.LastUsedAt,
+		RevokedAt:  record.RevokedAt,
+	}
+}
+
+// authenticateAPIKey resolves an active key to its identity
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, key string) (Identity, error) {
+	if len(key) < minAPIKeyLength {
+		return Identity{}, ErrUnauthenticated
+	}
+
+	record, err := database.UseAPIKey(ctx, a.db, HashAPIKey(key))
+	if errors.Is(err, pg.ErrNoRows) {
+		return Identity{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("error looking up API key: %w", database.MarkUnavailable(err))
+	}
+
+	roles := make([]Role, len(record.Roles))
+	for i, role := range record.Roles {
+		roles[i] = Role(role)
+	}
+	identity := Identity{Subject: record.Name, Method: "api_key", KeyID: record.ID, Roles: roles, Tenant: record.Tenant}
+	if record.UserID != nil {
+		user, err := database.GetUser(ctx, a.db, *record.UserID)
+		if err != nil {
+			return Identity{}, fmt.Errorf("error looking up the owner of API key %d: %w", record.ID, database.MarkUnavailable(err))
+		}
+		identity.UserID, identity.Preferences = user.ID, user.Preferences
+	}
+	return identity, nil
+}
+
+func roleNames(roles []Role) []string {
+	names := make([]string, len(roles))
+	for i, role := range roles {
+		names[i] = string(role)
+	}
+	return names
+}
+
+// looksLikeJWT tells bearer JWTs apart from API keys
+func looksLikeJWT(token string) bool {
+	return !strings.HasPrefix(token, apiKeyPrefix) && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"orchestrator/internal/models"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrForbidden       = errors.New("insufficient permissions")
+	ErrKeyNotFound     = errors.New("API key not found")
+)
+
+// Role grants access to a group of routes
+type Role string
+
+const (
+	// RoleIngest may submit documents and rows for embedding
+	RoleIngest Role = "ingest"
+	// RoleQuery may use the LLM endpoints
+	RoleQuery Role = "query"
+	// RoleAdmin may do everything, including managing API keys
+	RoleAdmin Role = "admin"
+)
+
+var AllRoles = []Role{RoleIngest, RoleQuery, RoleAdmin}
+
+// DefaultTenant owns data created before tenants existed and keys created without one
+const DefaultTenant = "default"
+
+// ParseRoles validates role names
+func ParseRoles(names []string) ([]Role, error) {
+	if len(names) == 0 {
+		return nil, errors.New("at least one role is required")
+	}
+	roles := make([]Role, 0, len(names))
+	for _, name := range names {
+		role := Role(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(AllRoles, role) {
+			return nil, fmt.Errorf("unknown role %q, expected ingest, query or admin", name)
+		}
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	// API key name or JWT subject
+	Subject string
+	// "api_key" or "jwt"
+	Method string
+	// Set for API keys
+	KeyID int64
+	Roles []Role
+	// Conversations and documents are only visible within their tenant
+	Tenant string
+	// User behind the key or JWT subject, if there is one
+	UserID int64
+	// Preferences of the user, applied as request defaults
+	Preferences models.UserPreferences
+}
+
+// HasRole reports whether the identity has the role; admins have every role
+func (i Identity) HasRole(role Role) bool {
+	return slices.Contains(i.Roles, role) || slices.Contains(i.Roles, RoleAdmin)
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the request, if it was authenticated
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// TenantFromContext returns the tenant of the authenticated caller. Data
+// access fails closed when there is none.
+func TenantFromContext(ctx context.Context) (string, error) {
+	identity, ok := FromContext(ctx)
+	if !ok || identity.Tenant == "" {
+		return "", fmt.Errorf("%w: no tenant", ErrUnauthenticated)
+	}
+	return identity.Tenant, nil
+}
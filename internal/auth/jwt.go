@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"orchestrator/internal/database"
+	"os"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig enables bearer JWTs. HS256 tokens are verified with Secret and
+// RS256 tokens with the RSA keys of the JWKS file; leave both empty to accept
+// API keys only.
+type JWTConfig struct {
+	Secret   string
+	JWKSFile string
+	// Checked when set
+	Issuer   string
+	Audience string
+}
+
+// jwtClaims are the claims we read; roles uses the same names as API keys.
+// Tokens without a tenant get a tenant of their own, named after the subject.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles"`
+	Tenant string   `json:"tenant"`
+}
+
+// Authenticator resolves API keys and JWTs to identities
+type Authenticator struct {
+	secret []byte
+	// RSA keys by key id
+	keys   map[string]*rsa.PublicKey
+	parser *jwt.Parser
+	// The shared pool, so that requests do not connect for their lookups
+	db *pg.DB
+}
+
+func NewAuthenticator(config JWTConfig) (*Authenticator, error) {
+	a := &Authenticator{}
+	var methods []string
+	if config.Secret != "" {
+		if len(config.Secret) < 32 {
+			return nil, errors.New("JWT secret must be at least 32 characters")
+		}
+		a.secret = []byte(config.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	a.parser = jwt.NewParser(options...)
+	a.db = database.Pool()
+	return a, nil
+}
+
+// Authenticate resolves a bearer token, either an API key or a JWT
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (Identity, error) {
+	if token == "" {
+		return Identity{}, ErrUnauthenticated
+	}
+	if looksLikeJWT(token) {
+		identity, err := a.authenticateJWT(token)
+		if err != nil {
+			return Identity{}, err
+		}
+		return a.withJWTUser(ctx, identity), nil
+	}
+	return a.authenticateAPIKey(ctx, token)
+}
+
+func (a *Authenticator) authenticateJWT(token string) (Identity, error) {
+	if a.secret == nil && a.keys == nil {
+		return Identity{}, fmt.Errorf("%w: JWTs are not accepted", ErrUnauthenticated)
+	}
+
+	var claims jwtClaims
+	_, err := a.parser.ParseWithClaims(token, &claims, a.verificationKey)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: token has no subject", ErrUnauthenticated)
+	}
+	roles, err := ParseRoles(claims.Roles)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+	tenant := claims.Tenant
+	if tenant == "" {
+		tenant = claims.Subject
+	}
+	return Identity{Subject: claims.Subject, Method: "jwt", Roles: roles, Tenant: tenant}, nil
+}
+
+func (a *Authenticator) verificationKey(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return a.secret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" && len(a.keys) == 1 {
+			for _, key := range a.keys {
+				return key, nil
+			}
+		}
+		key, ok := a.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// loadJWKS reads the RSA signing keys of a JSON Web Key Set file
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWKS file: %w", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &jwks); err != nil {
+		return nil, fmt.Errorf("error parsing JWKS file: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding modulus of key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding exponent of key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS file %s contains no RSA signing keys", path)
+	}
+	return keys, nil
+}
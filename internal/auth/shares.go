@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+
+	"github.com/go-pg/pg/v10"
+)
+
+var (
+	ErrShareNotFound = errors.New("collection is not shared with that tenant")
+	ErrSelfShare     = errors.New("a collection cannot be shared with its own tenant")
+)
+
+// ShareCollection lets another tenant retrieve the owner's documents of a collection
+func ShareCollection(ctx context.Context, owner string, collectionSlug string, sharedWith string) (models.CorpusShare, error) {
+	if sharedWith == owner {
+		return models.CorpusShare{}, ErrSelfShare
+	}
+	db := database.Pool()
+
+	share := database.CorpusShare{OwnerTenant: owner, CollectionSlug: collectionSlug, SharedWith: sharedWith}
+	if err := database.InsertCorpusShare(ctx, db, &share); err != nil {
+		return models.CorpusShare{}, err
+	}
+	return corpusShare(share), nil
+}
+
+// UnshareCollection returns ErrShareNotFound if the collection was not shared with the tenant
+func UnshareCollection(ctx context.Context, owner string, collectionSlug string, sharedWith string) error {
+	err := database.DeleteCorpusShare(ctx, database.Pool(), database.CorpusShare{OwnerTenant: owner, CollectionSlug: collectionSlug, SharedWith: sharedWith})
+	if errors.Is(err, pg.ErrNoRows) {
+		return ErrShareNotFound
+	}
+	return err
+}
+
+// ListShares returns the collections the tenant shared and those shared with it
+func ListShares(ctx context.Context, tenant string) ([]models.CorpusShare, error) {
+	db := database.Pool()
+
+	records, err := database.ListCorpusShares(ctx, db, tenant)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]models.CorpusShare, len(records))
+	for i, record := range records {
+		shares[i] = corpusShare(record)
+	}
+	return shares, nil
+}
+
+func corpusShare(record database.CorpusShare) models.CorpusShare {
+	return models.CorpusShare{
+		OwnerTenant:    record.OwnerTenant,
+		CollectionSlug: record.CollectionSlug,
+		SharedWith:     record.SharedWith,
+		CreatedAt:      record.CreatedAt,
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+)
+
+// CreateUser stores a user of the tenant, or of the default tenant if the request names none
+func CreateUser(ctx context.Context, request models.CreateUserRequest) (models.User, error) {
+	db := database.Pool()
+
+	record := database.User{
+		Tenant:      request.Tenant,
+		Name:        request.Name,
+		DisplayName: request.DisplayName,
+		Email:       request.Email,
+		Preferences: request.Preferences,
+	}
+	if record.Tenant == "" {
+		record.Tenant = DefaultTenant
+	}
+	if err := database.InsertUser(ctx, db, &record); err != nil {
+		return models.User{}, err
+	}
+	return user(record), nil
+}
+
+// GetUser returns database.ErrUserNotFound if there is no user with the id
+func GetUser(ctx context.Context, id int64) (models.User, error) {
+	db := database.Pool()
+
+	record, err := database.GetUser(ctx, db, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user(*record), nil
+}
+
+// CurrentUser returns the user behind the caller's credentials, or
+// database.ErrUserNotFound if they belong to no user
+func CurrentUser(ctx context.Context) (models.User, error) {
+	identity, ok := FromContext(ctx)
+	if !ok {
+		return models.User{}, ErrUnauthenticated
+	}
+	if identity.UserID == 0 {
+		return models.User{}, fmt.Errorf("%w: credentials of %s belong to no user", database.ErrUserNotFound, identity.Subject)
+	}
+	return GetUser(ctx, identity.UserID)
+}
+
+// ListUsers returns the users of every tenant
+func ListUsers(ctx context.Context) ([]models.User, error) {
+	db := database.Pool()
+
+	records, err := database.ListUsers(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]models.User, len(records))
+	for i, record := range records {
+		users[i] = user(record)
+	}
+	return users, nil
+}
+
+// UpdateUser changes the profile fields and preferences set in the request
+func UpdateUser(ctx context.Context, id int64, request models.UpdateUserRequest) (models.User, error) {
+	db := database.Pool()
+
+	record, err := database.GetUser(ctx, db, id)
+	if err != nil {
+		return models.User{}, err
+	}
+	if request.DisplayName != nil {
+		record.DisplayName = *request.DisplayName
+	}
+	if request.Email != nil {
+		record.Email = *request.Email
+	}
+	if request.Preferences != nil {
+		record.Preferences = *request.Preferences
+	}
+	if err := database.UpdateUser(ctx, db, record); err != nil {
+		return models.User{}, err
+	}
+	return user(*record), nil
+}
+
+// DeleteUser deletes the user and revokes their API keys
+func DeleteUser(ctx context.Context, id int64) error {
+	db := database.Pool()
+
+	return database.DeleteUser(ctx, db, id)
+}
+
+// withJWTUser attaches the user named by the JWT subject within its tenant.
+// Subjects without a user keep the configured defaults, as do all subjects
+// while the database cannot be reached, since JWTs are verified without it
+func (a *Authenticator) withJWTUser(ctx context.Context, identity Identity) Identity {
+	record, err := database.GetUserByName(ctx, a.db, identity.Tenant, identity.Subject)
+	if err != nil {
+		if !errors.Is(err, database.ErrUserNotFound) {
+			slog.WarnContext(ctx, "Error looking up the user of a JWT subject", "subject", identity.Subject, "error", err)
+		}
+		return identity
+	}
+	identity.UserID, identity.Preferences = record.ID, record.Preferences
+	return identity
+}
+
+func user(record database.User) models.User {
+	return models.User{
+		ID:          record.ID,
+		Tenant:      record.Tenant,
+		Name:        record.Name,
+		DisplayName: record.DisplayName,
+		Email:       record.Email,
+		Preferences: record.Preferences,
+		CreatedAt:   record.CreatedAt,
+		UpdatedAt:   record.UpdatedAt,
+	}
+}
@@ -0,0 +1,515 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"orchestrator/internal/models"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the orchestrator. It is read from an optional
+// YAML file, then overridden by environment variables
+type Config struct {
+	Server     Server     `yaml:"server" json:"server"`
+	HTTP       HTTP       `yaml:"http" json:"http"`
+	Database   Database   `yaml:"database" json:"database"`
+	Ollama     Ollama     `yaml:"ollama" json:"ollama"`
+	IPFS       IPFS       `yaml:"ipfs" json:"ipfs"`
+	Ingestion  Ingestion  `yaml:"ingestion" json:"ingestion"`
+	Chat       Chat       `yaml:"chat" json:"chat"`
+	Defaults   Defaults   `yaml:"defaults" json:"defaults"`
+	LLM        LLM        `yaml:"llm" json:"llm"`
+	Prompts    Prompts    `yaml:"prompts" json:"prompts"`
+	Auth       Auth       `yaml:"auth" json:"auth"`
+	RateLimits RateLimits `yaml:"rate_limits" json:"rate_limits"`
+	Logging    Logging    `yaml:"logging" json:"logging"`
+	Tracing    Tracing    `yaml:"tracing" json:"tracing"`
+}
+
+type Server struct {
+	// Address the HTTP server listens on, e.g. ":8080"
+	Listen string `yaml:"listen" json:"listen"`
+	// Address the gRPC server listens on, e.g. ":9090"; empty disables it
+	GRPCListen string `yaml:"grpc_listen" json:"grpc_listen"`
+	// Time given to requests and background jobs to finish on SIGTERM
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" json:"shutdown_timeout"`
+	// Serve the paths used before /v1 as deprecated aliases
+	LegacyRoutes bool `yaml:"legacy_routes" json:"legacy_routes"`
+	// Announced in the Sunset header of legacy paths; zero until it is decided
+	LegacySunset Date `yaml:"legacy_sunset" json:"legacy_sunset"`
+}
+
+// HTTP protects the API from browsers of other origins and oversized requests
+type HTTP struct {
+	CORS CORS `yaml:"cors" json:"cors"`
+	// Largest request body accepted, in bytes
+	MaxBodyBytes int `yaml:"max_body_bytes" json:"max_body_bytes"`
+	// Body limits replacing max_body_bytes, keyed by /v1 route
+	RouteBodyBytes map[string]int `yaml:"route_body_bytes" json:"route_body_bytes,omitempty"`
+	// Longest "input" of a query, in characters. It must leave room for the
+	// prompt instructions in the context window of defaults.chat_model
+	MaxInputLength int `yaml:"max_input_length" json:"max_input_length"`
+	// Send X-Content-Type-Options, X-Frame-Options, Referrer-Policy,
+	// Content-Security-Policy and Cache-Control with every response
+	SecurityHeaders bool `yaml:"security_headers" json:"security_headers"`
+	// Sent as Strict-Transport-Security when positive; only set it when clients reach the API over HTTPS
+	HSTSMaxAge Duration `yaml:"hsts_max_age" json:"hsts_max_age"`
+}
+
+type CORS struct {
+	// Origins such as "https://dashboard.example.com" allowed to call the
+	// API from a browser, or "*" for any. CORS is off when empty
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
+	// Request headers browsers may send besides the CORS-safelisted ones
+	AllowedHeaders []string `yaml:"allowed_headers" json:"allowed_headers"`
+	// Let browsers send cookies and HTTP authentication; requires explicit origins
+	AllowCredentials bool `yaml:"allow_credentials" json:"allow_credentials"`
+	// How long browsers may cache a preflight response
+	MaxAge Duration `yaml:"max_age" json:"max_age"`
+}
+
+type Database struct {
+	// host:port of the TimescaleDB server
+	Address  string `yaml:"address" json:"address"`
+	User     string `yaml:"user" json:"user"`
+	Password string `yaml:"password" json:"password"`
+	Name     string `yaml:"name" json:"name"`
+	// Role generated SQL runs as. It should only be granted SELECT on the GameFi tables
+	SQLRole string `yaml:"sql_role" json:"sql_role"`
+}
+
+type Ollama struct {
+	URL string `yaml:"url" json:"url"`
+}
+
+type IPFS struct {
+	// Base URL of the IPFS HTTP API, without /api/v0
+	URL string `yaml:"url" json:"url"`
+}
+
+type Ingestion struct {
+	// Characters per document chunk that gets its own embedding
+	ChunkSize int `yaml:"chunk_size" json:"chunk_size"`
+}
+
+type Chat struct {
+	// Previous messages of a conversation sent along with a chat query
+	HistoryLimit int `yaml:"history_limit" json:"history_limit"`
+}
+
+// Defaults fill in fields that API requests leave out
+type Defaults struct {
+	// Model answering queries
+	ChatModel string `yaml:"chat_model" json:"chat_model"`
+	// Model embedding documents and rows
+	EmbeddingModel string `yaml:"embedding_model" json:"embedding_model"`
+	// Results retrieved per data source in RAG queries
+	SearchLimit int `yaml:"search_limit" json:"search_limit"`
+}
+
+type LLM struct {
+	// Deadlines per pipeline stage, replacing the built-in ones
+	StageTimeouts map[string]Duration `yaml:"stage_timeouts" json:"stage_timeouts,omitempty"`
+	// Generation options per stage, merged over the built-in ones
+	StageOptions StageOptions `yaml:"stage_options" json:"stage_options,omitempty"`
+	// Tokens per model, taking precedence over the num_ctx reported by Ollama
+	ContextWindows map[string]int `yaml:"context_windows" json:"context_windows,omitempty"`
+}
+
+type Prompts struct {
+	// Directory of templates replacing the built-in prompts of the same name
+	Dir string `yaml:"dir" json:"dir"`
+}
+
+type Auth struct {
+	// Admin API key stored at startup to create the first keys
+	BootstrapAdminKey string `yaml:"bootstrap_admin_key" json:"bootstrap_admin_key"`
+	JWT               JWT    `yaml:"jwt" json:"jwt"`
+}
+
+type JWT struct {
+	Secret   string `yaml:"secret" json:"secret"`
+	JWKSFile string `yaml:"jwks_file" json:"jwks_file"`
+	Issuer   string `yaml:"issuer" json:"issuer"`
+	Audience string `yaml:"audience" json:"audience"`
+}
+
+type RateLimits struct {
+	// JSON file with the limits, reloaded when it changes
+	File string `yaml:"file" json:"file"`
+}
+
+type Logging struct {
+	Level  string `yaml:"level" json:"level"`
+	Format string `yaml:"format" json:"format"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" json:"exporter"`
+}
+
+// Duration is a time.Duration written as a Go duration string such as "90s"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Date is a day written as "2006-01-02"; the zero Date is written as ""
+type Date time.Time
+
+func (d Date) MarshalText() ([]byte, error) {
+	if time.Time(d).IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(time.Time(d).Format(time.DateOnly)), nil
+}
+
+func (d *Date) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*d = Date{}
+		return nil
+	}
+	parsed, err := time.Parse(time.DateOnly, string(text))
+	if err != nil {
+		return err
+	}
+	*d = Date(parsed)
+	return nil
+}
+
+// StageOptions are generation options keyed by stage. In YAML they use the
+// same field names as the "options" of API requests
+type StageOptions map[string]models.GenerationOptions
+
+func (s *StageOptions) UnmarshalYAML(node *yaml.Node) error {
+	var raw map[string]map[string]any
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, (*map[string]models.GenerationOptions)(s))
+}
+
+// Default returns the settings used when neither the file nor the environment sets them
+func Default() Config {
+	return Config{
+		Server: Server{Listen: ":8080", ShutdownTimeout: Duration(30 * time.Second), LegacyRoutes: true},
+		HTTP: HTTP{
+			CORS: CORS{
+				AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID"},
+				MaxAge:         Duration(10 * time.Minute),
+			},
+			MaxBodyBytes:    1 << 20,
+			MaxInputLength:  4000,
+			SecurityHeaders: true,
+		},
+		Database:  Database{SQLRole: "orchestrator_sql_reader"},
+		Ollama:    Ollama{URL: "http://localhost:11434"},
+		IPFS:      IPFS{URL: "http://127.0.0.1:5001"},
+		Ingestion: Ingestion{ChunkSize: 1000},
+		Chat:      Chat{HistoryLimit: 10},
+		Defaults:  Defaults{ChatModel: "llama3", EmbeddingModel: "nomic-embed-text", SearchLimit: 5},
+		Logging:   Logging{Level: "info", Format: "text"},
+		Tracing:   Tracing{Exporter: "none"},
+	}
+}
+
+var (
+	mu      sync.RWMutex
+	current = Default()
+)
+
+// Get returns the loaded config, or the defaults before Load was called
+func Get() Config {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Load reads the YAML file at path, if any, applies environment overrides,
+// validates the result and makes it the config returned by Get
+func Load(path string) (Config, error) {
+	config := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("error reading config: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("error parsing config %s: %w", path, err)
+		}
+	}
+	if err := applyEnv(&config); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = config
+	return config, nil
+}
+
+// applyEnv overrides the config with the environment variables the
+// orchestrator has always read, plus one for each setting that used to be
+// hardcoded
+func applyEnv(config *Config) error {
+	stringFields := map[string]*string{
+		"ORCHESTRATOR_LISTEN_ADDRESS":      &config.Server.Listen,
+		"ORCHESTRATOR_GRPC_LISTEN_ADDRESS": &config.Server.GRPCListen,
+		"TIMESCALE_ADDRESS":                &config.Database.Address,
+		"TIMESCALE_USER":                   &config.Database.User,
+		"TIMESCALE_PASSWORD":               &config.Database.Password,
+		"TIMESCALE_DATABASE":               &config.Database.Name,
+		"ORCHESTRATOR_SQL_ROLE":            &config.Database.SQLRole,
+		"ORCHESTRATOR_OLLAMA_URL":          &config.Ollama.URL,
+		"ORCHESTRATOR_IPFS_URL":            &config.IPFS.URL,
+		"ORCHESTRATOR_PROMPTS_DIR":         &config.Prompts.Dir,
+		"ORCHESTRATOR_CHAT_MODEL":          &config.Defaults.ChatModel,
+		"ORCHESTRATOR_EMBEDDING_MODEL":     &config.Defaults.EmbeddingModel,
+		"ORCHESTRATOR_BOOTSTRAP_ADMIN_KEY": &config.Auth.BootstrapAdminKey,
+		"ORCHESTRATOR_JWT_SECRET":          &config.Auth.JWT.Secret,
+		"ORCHESTRATOR_JWT_JWKS_FILE":       &config.Auth.JWT.JWKSFile,
+		"ORCHESTRATOR_JWT_ISSUER":          &config.Auth.JWT.Issuer,
+		"ORCHESTRATOR_JWT_AUDIENCE":        &config.Auth.JWT.Audience,
+		"ORCHESTRATOR_RATE_LIMITS_FILE":    &config.RateLimits.File,
+		"ORCHESTRATOR_LOG_LEVEL":           &config.Logging.Level,
+		"ORCHESTRATOR_LOG_FORMAT":          &config.Logging.Format,
+		"ORCHESTRATOR_TRACE_EXPORTER":      &config.Tracing.Exporter,
+	}
+	for name, field := range stringFields {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	ints := map[string]*int{
+		"ORCHESTRATOR_CHUNK_SIZE":       &config.Ingestion.ChunkSize,
+		"ORCHESTRATOR_HISTORY_LIMIT":    &config.Chat.HistoryLimit,
+		"ORCHESTRATOR_SEARCH_LIMIT":     &config.Defaults.SearchLimit,
+		"ORCHESTRATOR_MAX_BODY_BYTES":   &config.HTTP.MaxBodyBytes,
+		"ORCHESTRATOR_MAX_INPUT_LENGTH": &config.HTTP.MaxInputLength,
+	}
+	for name, field := range ints {
+		if value := os.Getenv(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer: %w", name, err)
+			}
+			*field = parsed
+		}
+	}
+
+	if value := os.Getenv("ORCHESTRATOR_LEGACY_ROUTES"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("ORCHESTRATOR_LEGACY_ROUTES must be a boolean: %w", err)
+		}
+		config.Server.LegacyRoutes = enabled
+	}
+	if value := os.Getenv("ORCHESTRATOR_LEGACY_SUNSET"); value != "" {
+		if err := config.Server.LegacySunset.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("ORCHESTRATOR_LEGACY_SUNSET must be a date: %w", err)
+		}
+	}
+
+	// ORCHESTRATOR_CORS_ALLOWED_ORIGINS=https://dashboard.example.com,https://admin.example.com
+	if value := os.Getenv("ORCHESTRATOR_CORS_ALLOWED_ORIGINS"); value != "" {
+		config.HTTP.CORS.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			config.HTTP.CORS.AllowedOrigins = append(config.HTTP.CORS.AllowedOrigins, strings.TrimSpace(origin))
+		}
+	}
+
+	if value := os.Getenv("ORCHESTRATOR_SHUTDOWN_TIMEOUT"); value != "" {
+		if err := config.Server.ShutdownTimeout.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("ORCHESTRATOR_SHUTDOWN_TIMEOUT must be a duration: %w", err)
+		}
+	}
+
+	// ORCHESTRATOR_TIMEOUT_<STAGE>=90s
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		stage, ok := strings.CutPrefix(name, "ORCHESTRATOR_TIMEOUT_")
+		if !ok || value == "" {
+			continue
+		}
+		var timeout Duration
+		if err := timeout.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("%s must be a duration: %w", name, err)
+		}
+		if config.LLM.StageTimeouts == nil {
+			config.LLM.StageTimeouts = make(map[string]Duration)
+		}
+		config.LLM.StageTimeouts[strings.ToLower(stage)] = timeout
+	}
+
+	// ORCHESTRATOR_STAGE_OPTIONS='{"synthesis": {"temperature": 0.4}}'
+	if value := os.Getenv("ORCHESTRATOR_STAGE_OPTIONS"); value != "" {
+		var options StageOptions
+		if err := json.Unmarshal([]byte(value), &options); err != nil {
+			return fmt.Errorf("ORCHESTRATOR_STAGE_OPTIONS must be a JSON object keyed by stage: %w", err)
+		}
+		if config.LLM.StageOptions == nil {
+			config.LLM.StageOptions = make(StageOptions)
+		}
+		for stage, stageOptions := range options {
+			config.LLM.StageOptions[stage] = stageOptions
+		}
+	}
+
+	// ORCHESTRATOR_CONTEXT_WINDOWS=llama3:8b=8192,mistral=32768
+	if value := os.Getenv("ORCHESTRATOR_CONTEXT_WINDOWS"); value != "" {
+		if config.LLM.ContextWindows == nil {
+			config.LLM.ContextWindows = make(map[string]int)
+		}
+		for _, entry := range strings.Split(value, ",") {
+			model, window, found := strings.Cut(strings.TrimSpace(entry), "=")
+			parsed, err := strconv.Atoi(window)
+			if !found || err != nil {
+				return fmt.Errorf("ORCHESTRATOR_CONTEXT_WINDOWS entry %q must be model=tokens", entry)
+			}
+			config.LLM.ContextWindows[model] = parsed
+		}
+	}
+	return nil
+}
+
+// DefaultContextWindow is the number of tokens Ollama runs models with unless
+// num_ctx says otherwise
+const DefaultContextWindow = 2048
+
+// roleNamePattern matches the role names that need no quoting in SQL
+var roleNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Validate rejects settings the orchestrator could not run with
+func (c Config) Validate() error {
+	var errs []error
+	if c.Server.Listen == "" {
+		errs = append(errs, errors.New("server.listen is required"))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("server.shutdown_timeout must be positive"))
+	}
+	if c.Database.Address == "" {
+		errs = append(errs, errors.New("database.address (TIMESCALE_ADDRESS) is required"))
+	}
+	if c.Database.Name == "" {
+		errs = append(errs, errors.New("database.name (TIMESCALE_DATABASE) is required"))
+	}
+	if !roleNamePattern.MatchString(c.Database.SQLRole) {
+		errs = append(errs, fmt.Errorf("database.sql_role must be a lowercase Postgres role name, got %q", c.Database.SQLRole))
+	}
+	for name, value := range map[string]string{"ollama.url": c.Ollama.URL, "ipfs.url": c.IPFS.URL} {
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s must be an absolute URL, got %q", name, value))
+		}
+	}
+	if c.HTTP.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("http.max_body_bytes must be positive"))
+	}
+	for route, limit := range c.HTTP.RouteBodyBytes {
+		if limit <= 0 {
+			errs = append(errs, fmt.Errorf("http.route_body_bytes.%s must be positive", route))
+		}
+	}
+	if c.HTTP.MaxInputLength <= 0 {
+		errs = append(errs, errors.New("http.max_input_length must be positive"))
+	}
+	if c.HTTP.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("http.hsts_max_age may not be negative"))
+	}
+	for _, origin := range c.HTTP.CORS.AllowedOrigins {
+		if origin == "*" {
+			if c.HTTP.CORS.AllowCredentials {
+				errs = append(errs, errors.New(`http.cors.allowed_origins may not contain "*" when allow_credentials is set`))
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			errs = append(errs, fmt.Errorf("http.cors.allowed_origins must hold origins such as https://example.com, got %q", origin))
+		}
+	}
+	if c.Ingestion.ChunkSize <= 0 {
+		errs = append(errs, errors.New("ingestion.chunk_size must be positive"))
+	}
+	if c.Chat.HistoryLimit < 0 {
+		errs = append(errs, errors.New("chat.history_limit may not be negative"))
+	}
+	if c.Defaults.ChatModel == "" || c.Defaults.EmbeddingModel == "" {
+		errs = append(errs, errors.New("defaults.chat_model and defaults.embedding_model are required"))
+	}
+	if c.Defaults.SearchLimit <= 0 {
+		errs = append(errs, errors.New("defaults.search_limit must be positive"))
+	}
+	for stage, timeout := range c.LLM.StageTimeouts {
+		if timeout <= 0 {
+			errs = append(errs, fmt.Errorf("llm.stage_timeouts.%s must be positive", stage))
+		}
+	}
+	for model, window := range c.LLM.ContextWindows {
+		if window <= 0 {
+			errs = append(errs, fmt.Errorf("llm.context_windows.%s must be positive", model))
+		}
+	}
+	if err := c.checkInputFits(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+const redacted = "[redacted]"
+
+// checkInputFits rejects an input limit whose longest inputs would not fit the
+// prompt budget of the default chat model: its context window less the quarter
+// kept free for the response. Tokens are estimated at four characters each
+func (c Config) checkInputFits() error {
+	window, ok := c.LLM.ContextWindows[c.Defaults.ChatModel]
+	if !ok || window <= 0 {
+		window = DefaultContextWindow
+	}
+	budget := window - window/4
+	if tokens := (c.HTTP.MaxInputLength + 3) / 4; c.HTTP.MaxInputLength > 0 && tokens > budget {
+		return fmt.Errorf("http.max_input_length of %d characters needs ~%d tokens, more than the %d the %d-token context window of %s leaves for the prompt; lower it or set llm.context_windows.%s",
+			c.HTTP.MaxInputLength, tokens, budget, window, c.Defaults.ChatModel, c.Defaults.ChatModel)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the config that is safe to show, with secrets masked
+func (c Config) Redacted() Config {
+	for _, secret := range []*string{&c.Database.Password, &c.Auth.BootstrapAdminKey, &c.Auth.JWT.Secret} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return c
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// valid returns the defaults completed with the settings that have none
+func valid() Config {
+	config := Default()
+	config.Database.Address = "localhost:5432"
+	config.Database.Name = "orchestrator"
+	return config
+}
+
+// clearEnv keeps the settings of the developer's environment out of Load
+func clearEnv(t *testing.T) {
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if strings.HasPrefix(name, "ORCHESTRATOR_") || strings.HasPrefix(name, "TIMESCALE_") {
+			t.Setenv(name, "")
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		// Substring of the error, empty when the config is valid
+		wantErr string
+	}{
+		{name: "defaults", modify: func(*Config) {}},
+		{
+			name:    "database address required",
+			modify:  func(c *Config) { c.Database.Address = "" },
+			wantErr: "database.address",
+		},
+		{
+			name:    "database name required",
+			modify:  func(c *Config) { c.Database.Name = "" },
+			wantErr: "database.name",
+		},
+		{
+			name:    "quoted SQL role",
+			modify:  func(c *Config) { c.Database.SQLRole = `reader"; DROP ROLE x; --` },
+			wantErr: "database.sql_role",
+		},
+		{
+			name:    "relative Ollama URL",
+			modify:  func(c *Config) { c.Ollama.URL = "localhost:11434" },
+			wantErr: "ollama.url",
+		},
+		{
+			name:    "zero search limit",
+			modify:  func(c *Config) { c.Defaults.SearchLimit = 0 },
+			wantErr: "defaults.search_limit",
+		},
+		{
+			name:    "missing embedding model",
+			modify:  func(c *Config) { c.Defaults.EmbeddingModel = "" },
+			wantErr: "defaults.embedding_model",
+		},
+		{
+			name: "wildcard origin with credentials",
+			modify: func(c *Config) {
+				c.HTTP.CORS.AllowedOrigins = []string{"*"}
+				c.HTTP.CORS.AllowCredentials = true
+			},
+			wantErr: "allow_credentials",
+		},
+		{
+			name:    "origin with a path",
+			modify:  func(c *Config) { c.HTTP.CORS.AllowedOrigins = []string{"https://example.com/app"} },
+			wantErr: "http.cors.allowed_origins",
+		},
+		{
+			name:    "non-positive context window",
+			modify:  func(c *Config) { c.LLM.ContextWindows = map[string]int{"llama3": 0} },
+			wantErr: "llm.context_windows.llama3",
+		},
+		{
+			// 6144 characters are ~1536 tokens, all the default window leaves
+			name:   "input that just fits the default context window",
+			modify: func(c *Config) { c.HTTP.MaxInputLength = 6144 },
+		},
+		{
+			name:    "input over the default context window",
+			modify:  func(c *Config) { c.HTTP.MaxInputLength = 6148 },
+			wantErr: "http.max_input_length of 6148 characters",
+		},
+		{
+			name: "input fits the configured context window of the chat model",
+			modify: func(c *Config) {
+				c.HTTP.MaxInputLength = 20000
+				c.LLM.ContextWindows = map[string]int{"llama3": 8192}
+			},
+		},
+		{
+			name: "context window of another model is ignored",
+			modify: func(c *Config) {
+				c.HTTP.MaxInputLength = 20000
+				c.LLM.ContextWindows = map[string]int{"mistral": 32768}
+			},
+			wantErr: "set llm.context_windows.llama3",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := valid()
+			test.modify(&config)
+			err := config.Validate()
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	clearEnv(t)
+	t.Cleanup(func() { current = Default() })
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+database:
+  address: db:5432
+  name: orchestrator
+defaults:
+  chat_model: mistral
+  search_limit: 8
+llm:
+  context_windows:
+    mistral: 32768
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ORCHESTRATOR_SEARCH_LIMIT", "12")
+	t.Setenv("ORCHESTRATOR_TIMEOUT_SYNTHESIS", "90s")
+	t.Setenv("ORCHESTRATOR_CONTEXT_WINDOWS", "llama3=8192")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		setting   string
+		got, want any
+	}{
+		{"database.address from the file", config.Database.Address, "db:5432"},
+		{"defaults.chat_model from the file", config.Defaults.ChatModel, "mistral"},
+		{"defaults.search_limit from the environment", config.Defaults.SearchLimit, 12},
+		{"defaults.embedding_model by default", config.Defaults.EmbeddingModel, "nomic-embed-text"},
+		{"http.max_input_length by default", config.HTTP.MaxInputLength, 4000},
+		{"database.sql_role by default", config.Database.SQLRole, "orchestrator_sql_reader"},
+		{"llm.stage_timeouts.synthesis from the environment", config.LLM.StageTimeouts["synthesis"], Duration(90 * time.Second)},
+		{"llm.context_windows.mistral from the file", config.LLM.ContextWindows["mistral"], 32768},
+		{"llm.context_windows.llama3 from the environment", config.LLM.ContextWindows["llama3"], 8192},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.setting, test.got, test.want)
+		}
+	}
+	if Get().Defaults.ChatModel != "mistral" {
+		t.Error("Get does not return the loaded config")
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown setting",
+			file:    "database:\n  adress: db:5432\n",
+			wantErr: "field adress not found",
+		},
+		{
+			name:    "integer that is not one",
+			env:     map[string]string{"ORCHESTRATOR_CHUNK_SIZE": "large"},
+			wantErr: "ORCHESTRATOR_CHUNK_SIZE must be an integer",
+		},
+		{
+			name:    "context window without a model",
+			env:     map[string]string{"ORCHESTRATOR_CONTEXT_WINDOWS": "8192"},
+			wantErr: "must be model=tokens",
+		},
+		{
+			name:    "invalid result",
+			env:     map[string]string{"ORCHESTRATOR_MAX_INPUT_LENGTH": "100000"},
+			wantErr: "invalid config",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			file := "database:\n  address: db:5432\n  name: orchestrator\n"
+			if test.file != "" {
+				file = test.file
+			}
+			if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := Load(path); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
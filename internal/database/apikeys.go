@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+func InsertAPIKey(ctx context.Context, db *pg.DB, key *APIKey) error {
+	if _, err := db.ModelContext(ctx, key).Insert(); err != nil {
+		return fmt.Errorf("error inserting API key: %w", err)
+	}
+	return nil
+}
+
+// lastUsedResolution is how stale last_used_at may get, so that busy keys do
+// not cost a write on every request
+const lastUsedResolution = time.Minute
+
+// UseAPIKey looks up an active key by its hash and records that it was used.
+// It returns pg.ErrNoRows if there is no such key or it was revoked.
+func UseAPIKey(ctx context.Context, db *pg.DB, keyHash string) (*APIKey, error) {
+	key := &APIKey{}
+	err := db.ModelContext(ctx, key).
+		Where("key_hash = ?", keyHash).
+		Where("revoked_at IS NULL").
+		Select()
+	if err != nil {
+		return nil, err
+	}
+	if key.LastUsedAt != nil && time.Since(*key.LastUsedAt) < lastUsedResolution {
+		return key, nil
+	}
+	_, err = db.ModelContext(ctx, key).
+		Set("last_used_at = now()").
+		WherePK().
+		Where("last_used_at IS NULL OR last_used_at < now() - make_interval(secs => ?)", lastUsedResolution.Seconds()).
+		Update()
+	if err != nil {
+		return nil, fmt.Errorf("error recording the use of API key %d: %w", key.ID, err)
+	}
+	return key, nil
+}
+
+func APIKeyExists(ctx context.Context, db *pg.DB, keyHash string) (bool, error) {
+	return db.ModelContext(ctx, (*APIKey)(nil)).Where("key_hash = ?", keyHash).Exists()
+}
+
+func ListAPIKeys(ctx context.Context, db *pg.DB) ([]APIKey, error) {
+	var keys []APIKey
+	err := db.ModelContext(ctx, &keys).Order("id").Select()
+	if err != nil {
+		return nil, fmt.Errorf("error listing API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey returns pg.ErrNoRows if there is no active key with the id
+func RevokeAPIKey(ctx context.Context, db *pg.DB, id int64) error {
+	result, err := db.ModelContext(ctx, (*APIKey)(nil)).
+		Set("revoked_at = now()").
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Update()
+	if err != nil {
+		return fmt.Errorf("error revoking API key: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pg.ErrNoRows
+	}
+	return nil
+}
@@ -2,32 +2,71 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"orchestrator/internal/config"
+	"sync"
 
 	"github.com/go-pg/pg/v10"
 )
 
-func CreateDatabaseConnectionFromEnv() (*pg.DB, error) {
-	db := pg.Connect(&pg.Options{
-		Addr:     os.Getenv("TIMESCALE_ADDRESS"),
-		User:     os.Getenv("TIMESCALE_USER"),
-		Password: os.Getenv("TIMESCALE_PASSWORD"),
-		Database: os.Getenv("TIMESCALE_DATABASE"),
-	})
-
-	err := db.Ping(context.Background())
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+// ErrUnavailable is returned when the database cannot be reached
+var ErrUnavailable = errors.New("database unavailable")
+
+var pool struct {
+	sync.Mutex
+	db *pg.DB
+}
+
+// Pool returns the connection pool shared by the process, created on first
+// use with the database settings of the loaded config, which TIMESCALE_*
+// environment variables override. Connections are only made when needed, so
+// the database may be down. Callers must not close it
+func Pool() *pg.DB {
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.db == nil {
+		settings := config.Get().Database
+		pool.db = pg.Connect(&pg.Options{
+			Addr:     settings.Address,
+			User:     settings.User,
+			Password: settings.Password,
+			Database: settings.Name,
+		})
+		pool.db.AddQueryHook(queryHook{})
+	}
+	return pool.db
+}
+
+// Close closes the shared pool once the process is done with the database
+func Close() error {
+	pool.Lock()
+	defer pool.Unlock()
+	if pool.db == nil {
+		return nil
 	}
+	err := pool.db.Close()
+	pool.db = nil
+	return err
+}
 
-	return db, nil
+// MarkUnavailable wraps errors of reaching the database with ErrUnavailable,
+// leaving the errors Postgres answered with and those of the caller's context
+// as they are
+func MarkUnavailable(err error) error {
+	var pgErr pg.Error
+	if err == nil || errors.As(err, &pgErr) || errors.Is(err, pg.ErrNoRows) || errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrUnavailable) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
 }
 
 func CreatePostgresDSN() string {
+	settings := config.Get().Database
 	return fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
-		os.Getenv("TIMESCALE_USER"),
-		os.Getenv("TIMESCALE_PASSWORD"),
-		os.Getenv("TIMESCALE_ADDRESS"),
-		os.Getenv("TIMESCALE_DATABASE"))
+		settings.User,
+		settings.Password,
+		settings.Address,
+		settings.Name)
 }
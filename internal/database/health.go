@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// CheckReady verifies that the database accepts connections and has the
+// pgvector extension the embeddings are stored with
+func CheckReady(ctx context.Context) error {
+	db := Pool()
+	if err := db.Ping(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	var installed bool
+	if _, err := db.QueryOneContext(ctx, &installed, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector')`); err != nil {
+		return err
+	}
+	if !installed {
+		return errors.New("pgvector extension is not installed")
+	}
+	return nil
+}
+
+// CheckSQLRole verifies that generated SQL can be run as database.sql_role
+func CheckSQLRole(ctx context.Context) error {
+	db := Pool()
+
+	return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		return restrictToSQLRole(ctx, tx)
+	})
+}
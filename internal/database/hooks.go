@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/tracing"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OrchestratorTables hold the orchestrator's own, per-tenant data as opposed
+// to the shared GameFi data in TableNames
+var OrchestratorTables = []string{"documents", "conversations", "messages", "api_keys", "corpus_shares", "users"}
+
+var tablePattern = regexp.MustCompile(`(?i)\b(?:FROM|INTO|UPDATE|TABLE)\s+"?([a-z_][a-z0-9_.]*)`)
+
+// queryHook traces every query and records its latency per table and operation
+type queryHook struct{}
+
+func (queryHook) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	table, operation := describeQuery(event)
+	ctx, _ = tracing.Start(ctx, "db."+operation+" "+table,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+		attribute.String("db.sql.table", table),
+	)
+	return ctx, nil
+}
+
+func (queryHook) AfterQuery(ctx context.Context, event *pg.QueryEvent) error {
+	table, operation := describeQuery(event)
+	duration := time.Since(event.StartTime)
+	span := trace.SpanFromContext(ctx)
+	metrics.DBQueryDuration.WithLabelValues(table, operation).Observe(duration.Seconds())
+	if event.Err != nil && event.Err != pg.ErrNoRows {
+		metrics.DBQueryErrors.WithLabelValues(table, operation).Inc()
+		slog.WarnContext(ctx, "Database query failed", "table", table, "operation", operation, "duration", duration, "error", event.Err)
+		tracing.End(span, event.Err)
+		return nil
+	}
+	if event.Result != nil {
+		span.SetAttributes(attribute.Int("db.rows", max(event.Result.RowsReturned(), event.Result.RowsAffected())))
+	}
+	slog.DebugContext(ctx, "Database query", "table", table, "operation", operation, "duration", duration)
+	tracing.End(span, nil)
+	return nil
+}
+
+// describeQuery returns the table and operation of a query. Raw queries,
+// including those generated by the LLM, are labelled by the first table they
+// name; tables we do not know are labelled "other" to bound cardinality.
+func describeQuery(event *pg.QueryEvent) (string, string) {
+	if command, ok := event.Query.(orm.QueryCommand); ok {
+		table := "none"
+		if model := command.Query().TableModel(); model != nil {
+			table = knownTable(strings.Trim(string(model.Table().SQLName), `"`))
+		}
+		return table, strings.ToLower(string(command.Operation()))
+	}
+
+	query, err := event.UnformattedQuery()
+	if err != nil {
+		return "other", "other"
+	}
+	fields := strings.Fields(string(query))
+	if len(fields) == 0 {
+		return "none", "other"
+	}
+	operation := strings.ToLower(fields[0])
+	match := tablePattern.FindSubmatch(query)
+	if match == nil {
+		return "none", operation
+	}
+	return knownTable(strings.ToLower(string(match[1]))), operation
+}
+
+func knownTable(table string) string {
+	if strings.HasPrefix(table, "information_schema.") {
+		return "information_schema"
+	}
+	table = strings.TrimPrefix(table, "public.")
+	if slices.Contains(TableNames, table) || slices.Contains(OrchestratorTables, table) {
+		return table
+	}
+	return "other"
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// migrations bring the orchestrator's own tables up to date. They run in
+// order at startup and must be safe to run again.
+var migrations = []string{
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS usage jsonb`,
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id bigserial PRIMARY KEY,
+		name text NOT NULL,
+		prefix text NOT NULL,
+		key_hash text NOT NULL UNIQUE,
+		roles text[] NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		last_used_at timestamptz,
+		revoked_at timestamptz
+	)`,
+	`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT 'default'`,
+	`ALTER TABLE conversations ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT 'default'`,
+	`ALTER TABLE documents ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT 'default'`,
+	`CREATE INDEX IF NOT EXISTS conversations_tenant_idx ON conversations (tenant)`,
+	`CREATE INDEX IF NOT EXISTS documents_tenant_collection_idx ON documents (tenant, collection_slug)`,
+	`CREATE TABLE IF NOT EXISTS corpus_shares (
+		owner_tenant text NOT NULL,
+		collection_slug text NOT NULL,
+		shared_with text NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		PRIMARY KEY (owner_tenant, collection_slug, shared_with)
+	)`,
+	`CREATE TABLE IF NOT EXISTS users (
+		id bigserial PRIMARY KEY,
+		tenant text NOT NULL DEFAULT 'default',
+		name text NOT NULL,
+		display_name text NOT NULL DEFAULT '',
+		email text NOT NULL DEFAULT '',
+		preferences jsonb NOT NULL DEFAULT '{}',
+		created_at timestamptz NOT NULL DEFAULT now(),
+		updated_at timestamptz NOT NULL DEFAULT now(),
+		UNIQUE (tenant, name)
+	)`,
+	`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users (id) ON DELETE SET NULL`,
+}
+
+// Migrate applies the migrations
+func Migrate(ctx context.Context) error {
+	db := Pool()
+
+	for _, migration := range migrations {
+		if _, err := db.ExecContext(ctx, migration); err != nil {
+			return fmt.Errorf("error applying migration %q: %w", migration, err)
+		}
+	}
+	return nil
+}
@@ -1,37 +1,49 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"orchestrator/internal/config"
 	"orchestrator/internal/models"
 	"reflect"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"github.com/pgvector/pgvector-go"
 )
 
-func GetTableSchemaAsString() (string, error) {
-	db, err := CreateDatabaseConnectionFromEnv()
-	if err != nil {
-		return "", err
-	}
-	defer db.Close()
+var (
+	// ErrNotFound is returned when a row the caller asked for does not exist or is not theirs
+	ErrNotFound             = errors.New("not found")
+	ErrConversationNotFound = fmt.Errorf("conversation %w", ErrNotFound)
+	ErrUserNotFound         = fmt.Errorf("user %w", ErrNotFound)
+	// ErrUserExists is returned when the tenant already has a user of that name
+	ErrUserExists = errors.New("user already exists")
+	// ErrInvalidRowKey is returned when a row primary key names no columns or columns the table lacks
+	ErrInvalidRowKey = errors.New("invalid row primary key")
+	// ErrSQLRole is returned when generated SQL cannot be run as database.sql_role
+	ErrSQLRole = errors.New("cannot switch to the SQL role")
+)
 
+func GetTableSchemaAsString(ctx context.Context, db *pg.DB) (string, error) {
 	var tables []struct {
 		TableName string
 		Columns   string
 	}
 
-	_, err = db.Query(&tables, `
+	_, err := db.QueryContext(ctx, &tables, `
         SELECT table_name, 
                string_agg(column_name || ' ' || data_type, ', ' ORDER BY ordinal_position) AS columns
         FROM information_schema.columns 
-        WHERE table_schema = 'public' 
+        WHERE table_schema = 'public' AND table_name IN (?)
         GROUP BY table_name
         ORDER BY table_name
-    `)
+    `, pg.In(TableNames))
 	if err != nil {
 		return "", err
 	}
@@ -43,7 +55,7 @@ func GetTableSchemaAsString() (string, error) {
 	return schema.String(), nil
 }
 
-func GetRowAsAString(request models.RowEmbeddingsRequest) (string, error) {
+func GetRowAsAString(ctx context.Context, db *pg.DB, request models.RowEmbeddingsRequest) (string, error) {
 	// Get the struct type for the table
 	structType := GetTableStruct(request.Table)
 	if structType == nil {
@@ -59,19 +71,11 @@ func GetRowAsAString(request models.RowEmbeddingsRequest) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to unmarshal primary keys: %v", err)
 	}
-	var db *pg.DB
-	db, err = CreateDatabaseConnectionFromEnv()
-	if err != nil {
-		return "", err
-	}
-	defer db.Close()
 
 	// Create a query
-	query := db.Model(row).ExcludeColumn("embedding")
-
-	// Add WHERE clauses for each primary key
-	for key, value := range primaryKeys {
-		query = query.Where(fmt.Sprintf("%s = ?", key), value)
+	query, err := wherePrimaryKey(db.ModelContext(ctx, row).ExcludeColumn("embedding"), structType, request.Table, primaryKeys)
+	if err != nil {
+		return "", err
 	}
 
 	// Execute the query
@@ -89,10 +93,11 @@ func GetRowAsAString(request models.RowEmbeddingsRequest) (string, error) {
 	return string(result), nil
 }
 
-func InsertDocumentEmbedding(db *pg.DB, request models.DocumentEmbeddingsRequest, content string, embedding pgvector.Vector) error {
+func InsertDocumentEmbedding(ctx context.Context, db *pg.DB, tenant string, request models.DocumentEmbeddingsRequest, content string, embedding pgvector.Vector) error {
 	embeddingFloat32 := embedding.Slice()
 
 	doc := &Document{
+		Tenant:         tenant,
 		CollectionSlug: request.CollectionSlug,
 		CID:            request.CID,
 		Content:        content,
@@ -100,7 +105,7 @@ func InsertDocumentEmbedding(db *pg.DB, request models.DocumentEmbeddingsRequest
 		EventTimestamp: time.Now().UTC(),
 	}
 
-	_, err := db.Model(doc).Insert()
+	_, err := db.ModelContext(ctx, doc).Insert()
 	if err != nil {
 		return fmt.Errorf("failed to insert document embedding: %w", err)
 	}
@@ -108,7 +113,7 @@ func InsertDocumentEmbedding(db *pg.DB, request models.DocumentEmbeddingsRequest
 	return nil
 }
 
-func InsertRowEmbedding(request models.RowEmbeddingsRequest, embedding pgvector.Vector) error {
+func InsertRowEmbedding(ctx context.Context, db *pg.DB, request models.RowEmbeddingsRequest, embedding pgvector.Vector) error {
 	// Get the struct type for the table
 	structType := GetTableStruct(request.Table)
 	if structType == nil {
@@ -125,19 +130,11 @@ func InsertRowEmbedding(request models.RowEmbeddingsRequest, embedding pgvector.
 		return fmt.Errorf("failed to unmarshal primary keys: %v", err)
 	}
 
-	var db *pg.DB
-	db, err = CreateDatabaseConnectionFromEnv()
+	// Create a query
+	query, err := wherePrimaryKey(db.ModelContext(ctx, row).Set("embedding = ?", embedding), structType, request.Table, primaryKeys)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	// Create a query
-	query := db.Model(row).Set("embedding = ?", embedding)
-
-	// Add WHERE clauses for each primary key
-	for key, value := range primaryKeys {
-		query = query.Where(fmt.Sprintf("%s = ?", key), value)
-	}
 
 	// Execute the update
 	_, err = query.Update()
@@ -148,30 +145,59 @@ func InsertRowEmbedding(request models.RowEmbeddingsRequest, embedding pgvector.
 	return nil
 }
 
+// wherePrimaryKey adds a WHERE clause for each column of the primary key. The
+// column names come from the caller, so they must be columns of the table
+func wherePrimaryKey(query *orm.Query, structType reflect.Type, table string, primaryKeys map[string]interface{}) (*orm.Query, error) {
+	if len(primaryKeys) == 0 {
+		return nil, fmt.Errorf("%w: no columns given", ErrInvalidRowKey)
+	}
+	columns := tableColumns(structType)
+	for key, value := range primaryKeys {
+		if !slices.Contains(columns, key) {
+			return nil, fmt.Errorf("%w: %s has no column %q", ErrInvalidRowKey, table, key)
+		}
+		query = query.Where("? = ?", pg.Ident(key), value)
+	}
+	return query, nil
+}
+
+// tableColumns returns the column names in the pg tags of a table struct
+func tableColumns(structType reflect.Type) []string {
+	var columns []string
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("pg"), ",")
+		if field.IsExported() && name != "" && name != "-" {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+// ConstructSimilarDocumentsQuery searches the documents of the tenant and the
+// collections shared with it; the tenant is passed twice as query parameter
 func ConstructSimilarDocumentsQuery(queryEmbedding pgvector.Vector, limit int) string {
 	embeddingStr := fmt.Sprintf("%v", queryEmbedding)
 
 	query := fmt.Sprintf(`
-        SELECT collection_slug, content
+        SELECT collection_slug, cid, content, embedding <=> '%s'::vector AS distance
         FROM documents
-        ORDER BY embedding <=> '%s'::vector
+        WHERE tenant = ?
+           OR (tenant, collection_slug) IN (
+               SELECT owner_tenant, collection_slug FROM corpus_shares WHERE shared_with = ?
+           )
+        ORDER BY distance
         LIMIT %d
     `, embeddingStr, limit)
 
 	return query
 }
 
-// TODO handle no results gracefully
-func GetSimilarRowsFromTable(tableName string, queryEmbedding pgvector.Vector, limit int) ([]map[string]interface{}, error) {
-	//fmt.Printf("GetSimilarRowsFromTable: %s\n", tableName)
-	db, err := CreateDatabaseConnectionFromEnv()
-	if err != nil {
-
-	}
-	defer db.Close()
-
+// Returns an empty slice when the table has no rows with embeddings. Each row
+// carries its cosine distance to the query under "similarity_distance".
+func GetSimilarRowsFromTable(ctx context.Context, db *pg.DB, tableName string, queryEmbedding pgvector.Vector, limit int) ([]map[string]interface{}, error) {
 	var rows []json.RawMessage
-	_, err = db.Query(&rows, fmt.Sprintf(`
+	_, err := db.QueryContext(ctx, &rows, fmt.Sprintf(`
         SELECT jsonb_object_agg(
             key,
             CASE 
@@ -180,9 +206,10 @@ func GetSimilarRowsFromTable(tableName string, queryEmbedding pgvector.Vector, l
             END
         ) as row
         FROM (
-            SELECT *
+            SELECT *, embedding <=> ?::vector AS similarity_distance
             FROM %s
-            ORDER BY embedding <=> ?::vector
+            WHERE embedding IS NOT NULL
+            ORDER BY similarity_distance
             LIMIT ?
         ) r,
         LATERAL jsonb_each(to_jsonb(r))
@@ -193,10 +220,6 @@ func GetSimilarRowsFromTable(tableName string, queryEmbedding pgvector.Vector, l
 		return nil, fmt.Errorf("error querying similar rows: %w", err)
 	}
 
-	if len(rows) == 0 {
-		return nil, fmt.Errorf("no similar rows found in table %s", tableName)
-	}
-
 	// Unmarshal the JSON in each row
 	var result []map[string]interface{}
 	for _, row := range rows {
@@ -210,37 +233,62 @@ func GetSimilarRowsFromTable(tableName string, queryEmbedding pgvector.Vector, l
 	return result, nil
 }
 
-func GetRecentMessages(db *pg.DB, conversationID int64, limit int) ([]Message, error) {
-	//fmt.Printf("GetRecentMessages\n")
+func GetRecentMessages(ctx context.Context, db *pg.DB, tenant string, conversationID int64, limit int) ([]Message, error) {
 	var messages []Message
-	err := db.Model(&messages).
-		Where("conversation_id = ?", conversationID).
-		Order("created_at ASC").
+	err := db.ModelContext(ctx, &messages).
+		Join("JOIN conversations AS c ON c.id = message.conversation_id").
+		Where("message.conversation_id = ?", conversationID).
+		Where("c.tenant = ?", tenant).
+		Order("message.created_at DESC", "message.id DESC").
 		Limit(limit).
 		Select()
+	// Oldest first, as the messages are replayed to the model in order
+	slices.Reverse(messages)
 	return messages, err
 }
 
-func GetOrCreateConversation(db *pg.DB, conversationID int64, title string) (*Conversation, error) {
-	conversation := &Conversation{ID: conversationID}
-	err := db.Model(conversation).WherePK().Select()
+// GetConversation returns ErrConversationNotFound unless the conversation
+// exists and belongs to the tenant, so other tenants' ids cannot be probed
+func GetConversation(ctx context.Context, db *pg.DB, tenant string, conversationID int64) (*Conversation, error) {
+	if tenant == "" {
+		return nil, errors.New("tenant is required")
+	}
+	conversation := &Conversation{}
+	err := db.ModelContext(ctx, conversation).
+		Where("id = ?", conversationID).
+		Where("tenant = ?", tenant).
+		Select()
 	if err == pg.ErrNoRows {
-		// Conversation doesn't exist, create a new one
-		conversation = &Conversation{
-			Title: title,
-		}
-		_, err = db.Model(conversation).Insert()
-		if err != nil {
-			return nil, fmt.Errorf("error creating new conversation: %w", err)
-		}
-	} else if err != nil {
+		return nil, fmt.Errorf("%w: %d", ErrConversationNotFound, conversationID)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("error retrieving conversation: %w", err)
 	}
 	return conversation, nil
 }
 
-func SaveMessages(db *pg.DB, conversationID int64, messages []Message, title string) error {
-	conversation, err := GetOrCreateConversation(db, conversationID, title)
+// GetOrCreateConversation starts a new conversation of the tenant when
+// conversationID is 0 and otherwise returns the tenant's conversation
+func GetOrCreateConversation(ctx context.Context, db *pg.DB, tenant string, conversationID int64, title string) (*Conversation, error) {
+	if conversationID != 0 {
+		return GetConversation(ctx, db, tenant, conversationID)
+	}
+	if tenant == "" {
+		return nil, errors.New("tenant is required")
+	}
+	conversation := &Conversation{
+		Tenant: tenant,
+		Title:  title,
+	}
+	_, err := db.ModelContext(ctx, conversation).Insert()
+	if err != nil {
+		return nil, fmt.Errorf("error creating new conversation: %w", err)
+	}
+	return conversation, nil
+}
+
+func SaveMessages(ctx context.Context, db *pg.DB, tenant string, conversationID int64, messages []Message, title string) error {
+	conversation, err := GetOrCreateConversation(ctx, db, tenant, conversationID, title)
 	if err != nil {
 		return err
 	}
@@ -248,7 +296,7 @@ func SaveMessages(db *pg.DB, conversationID int64, messages []Message, title str
 	for _, msg := range messages {
 		msg.ConversationID = conversation.ID
 		msg.Conversation = conversation
-		_, err := db.Model(&msg).Insert()
+		_, err := db.ModelContext(ctx, &msg).Insert()
 		if err != nil {
 			return fmt.Errorf("error inserting message: %w", err)
 		}
@@ -256,41 +304,66 @@ func SaveMessages(db *pg.DB, conversationID int64, messages []Message, title str
 	return nil
 }
 
-func GetSimilaritySearchDocuments(db *pg.DB, embedding pgvector.Vector, searchLimit int) ([]Document, error) {
-	var documents []Document
+func GetSimilaritySearchDocuments(ctx context.Context, db *pg.DB, tenant string, embedding pgvector.Vector, searchLimit int) ([]DocumentMatch, error) {
+	if tenant == "" {
+		return nil, errors.New("tenant is required")
+	}
+	var documents []DocumentMatch
 	query := ConstructSimilarDocumentsQuery(embedding, searchLimit)
-	_, err := db.Query(&documents, query)
+	_, err := db.QueryContext(ctx, &documents, query, tenant, tenant)
 	return documents, err
 }
 
-func GetAllSimilarRowsFromDB(db *pg.DB, embedding pgvector.Vector, searchLimit int) (map[string][]map[string]interface{}, error) {
+func GetAllSimilarRowsFromDB(ctx context.Context, db *pg.DB, embedding pgvector.Vector, searchLimit int) (map[string][]map[string]interface{}, error) {
 	results := make(map[string][]map[string]interface{})
 	for _, tableName := range TableNames {
-		rows, err := GetSimilarRowsFromTable(tableName, embedding, searchLimit)
+		rows, err := GetSimilarRowsFromTable(ctx, db, tableName, embedding, searchLimit)
 		if err != nil {
 			return nil, fmt.Errorf("error searching table %s: %w", tableName, err)
 		}
-		results[tableName] = rows
+		if len(rows) > 0 {
+			results[tableName] = rows
+		}
 	}
 	return results, nil
 }
 
-func ExecuteSQLQuery(db *pg.DB, query string) ([]map[string]interface{}, error) {
+// ExecuteSQLQuery runs a generated query in a read-only transaction as
+// database.sql_role, so that it can only read what that role was granted
+func ExecuteSQLQuery(ctx context.Context, db *pg.DB, query string) ([]map[string]interface{}, error) {
 	var result []map[string]interface{}
-	_, err := db.Query(&result, query)
-	if err != nil {
-		return nil, fmt.Errorf("error executing SQL query: %w", err)
+	err := db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		if err := restrictToSQLRole(ctx, tx); err != nil {
+			return err
+		}
+		if _, err := tx.QueryContext(ctx, &result, query); err != nil {
+			return fmt.Errorf("error executing SQL query: %w", err)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// restrictToSQLRole makes the rest of the transaction read-only and run as
+// database.sql_role
+func restrictToSQLRole(ctx context.Context, tx *pg.Tx) error {
+	role := config.Get().Database.SQLRole
+	if _, err := tx.ExecContext(ctx, "SET TRANSACTION READ ONLY"); err != nil {
+		return fmt.Errorf("error making the transaction read-only: %w", err)
 	}
-	return result, nil
+	if _, err := tx.ExecContext(ctx, "SET LOCAL ROLE ?", pg.Ident(role)); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrSQLRole, role, err)
+	}
+	return nil
 }
 
-func SaveConversationAsMessages(db *pg.DB, conversationID int64, userInput, assistantResponse string) error {
+func SaveConversationAsMessages(ctx context.Context, db *pg.DB, tenant string, conversationID int64, userInput, assistantResponse string) error {
 	if conversationID == 0 {
 		return nil
 	}
 
 	title := fmt.Sprintf("Simple Query: %s", userInput)
-	err := SaveMessages(db, conversationID, []Message{
+	err := SaveMessages(ctx, db, tenant, conversationID, []Message{
 		{Role: "user", Content: userInput},
 		{Role: "assistant", Content: assistantResponse},
 	}, title)
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// InsertCorpusShare shares a collection; sharing it again is a no-op
+func InsertCorpusShare(ctx context.Context, db *pg.DB, share *CorpusShare) error {
+	_, err := db.ModelContext(ctx, share).OnConflict("DO NOTHING").Insert()
+	if err != nil {
+		return fmt.Errorf("error inserting corpus share: %w", err)
+	}
+	return nil
+}
+
+// DeleteCorpusShare returns pg.ErrNoRows if the collection was not shared
+func DeleteCorpusShare(ctx context.Context, db *pg.DB, share CorpusShare) error {
+	result, err := db.ModelContext(ctx, &share).WherePK().Delete()
+	if err != nil {
+		return fmt.Errorf("error deleting corpus share: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return pg.ErrNoRows
+	}
+	return nil
+}
+
+// ListCorpusShares returns the shares the tenant made and received
+func ListCorpusShares(ctx context.Context, db *pg.DB, tenant string) ([]CorpusShare, error) {
+	var shares []CorpusShare
+	err := db.ModelContext(ctx, &shares).
+		Where("owner_tenant = ?", tenant).
+		WhereOr("shared_with = ?", tenant).
+		Order("owner_tenant", "collection_slug", "shared_with").
+		Select()
+	if err != nil {
+		return nil, fmt.Errorf("error listing corpus shares: %w", err)
+	}
+	return shares, nil
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"orchestrator/internal/models"
 	"reflect"
 	"time"
 )
@@ -10,6 +11,8 @@ type RelevantData struct {
 	SimilarDocuments []Document
 }
 
+// GetTableStruct returns the struct of a table in TableNames, or nil. Tenant
+// data such as documents is not looked up by caller-supplied keys
 func GetTableStruct(tableName string) reflect.Type {
 	tableMap := map[string]reflect.Type{
 		"collection":         reflect.TypeOf(Collection{}),
@@ -25,7 +28,6 @@ func GetTableStruct(tableName string) reflect.Type {
 		"nft_dynamic":        reflect.TypeOf(NFTDynamic{}),
 		"token_price":        reflect.TypeOf(TokenPrice{}),
 		"nft_events":         reflect.TypeOf(NFTEvent{}),
-		"documents":          reflect.TypeOf(Document{}),
 	}
 	return tableMap[tableName]
 }
@@ -261,6 +263,7 @@ type NFTListing struct {
 
 type Document struct {
 	tableName      struct{}  `pg:"documents"`
+	Tenant         string    `pg:"tenant,notnull"`
 	CollectionSlug string    `pg:"collection_slug,notnull"`
 	CID            string    `pg:"cid,pk"`
 	Content        string    `pg:"content"`
@@ -268,9 +271,41 @@ type Document struct {
 	EventTimestamp time.Time `pg:"event_timestamp,pk,type:timestamptz"`
 }
 
+// User is a person or service behind API keys or JWTs, with the preferences
+// applied to their requests
+type User struct {
+	tableName   struct{}               `pg:"users"`
+	ID          int64                  `pg:"id,pk"`
+	Tenant      string                 `pg:"tenant,notnull"`
+	Name        string                 `pg:"name,notnull"`
+	DisplayName string                 `pg:"display_name,use_zero"`
+	Email       string                 `pg:"email,use_zero"`
+	Preferences models.UserPreferences `pg:"preferences,type:jsonb"`
+	CreatedAt   time.Time              `pg:"created_at,default:now()"`
+	UpdatedAt   time.Time              `pg:"updated_at,default:now()"`
+}
+
+// CorpusShare lets another tenant retrieve the documents of one of the owner's collections
+type CorpusShare struct {
+	tableName      struct{}  `pg:"corpus_shares"`
+	OwnerTenant    string    `pg:"owner_tenant,pk"`
+	CollectionSlug string    `pg:"collection_slug,pk"`
+	SharedWith     string    `pg:"shared_with,pk"`
+	CreatedAt      time.Time `pg:"created_at,default:now()"`
+}
+
+// DocumentMatch is a document chunk returned by a similarity search
+type DocumentMatch struct {
+	CollectionSlug string  `pg:"collection_slug"`
+	CID            string  `pg:"cid"`
+	Content        string  `pg:"content"`
+	Distance       float64 `pg:"distance"`
+}
+
 type Conversation struct {
 	tableName struct{}  `pg:"conversations"`
 	ID        int64     `pg:"id,pk"`
+	Tenant    string    `pg:"tenant,notnull"`
 	Title     string    `pg:"title,notnull"`
 	CreatedAt time.Time `pg:"created_at,default:current_timestamp"`
 	Messages  []Message `pg:"rel:has-many"`
@@ -285,4 +320,22 @@ type Message struct {
 	CreatedAt      time.Time     `pg:"created_at,default:current_timestamp"`
 	Conversation   *Conversation `pg:"rel:has-one"`
 	IsSummary      bool          `pg:"is_summary,notnull,default:false"`
+	// Model usage that produced an assistant message
+	Usage *models.UsageReport `pg:"usage,type:jsonb"`
+}
+
+// APIKey is a credential for the API. Only a hash of the key is stored; the
+// prefix is kept to tell keys apart.
+type APIKey struct {
+	tableName  struct{}   `pg:"api_keys"`
+	ID         int64      `pg:"id,pk"`
+	Name       string     `pg:"name,notnull"`
+	Tenant     string     `pg:"tenant,notnull"`
+	Prefix     string     `pg:"prefix,notnull"`
+	KeyHash    string     `pg:"key_hash,notnull"`
+	Roles      []string   `pg:"roles,array,notnull"`
+	UserID     *int64     `pg:"user_id"`
+	CreatedAt  time.Time  `pg:"created_at,default:now()"`
+	LastUsedAt *time.Time `pg:"last_used_at"`
+	RevokedAt  *time.Time `pg:"revoked_at"`
 }
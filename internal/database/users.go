@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// uniqueViolation is the Postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+// InsertUser returns ErrUserExists if the tenant already has a user of that name
+func InsertUser(ctx context.Context, db *pg.DB, user *User) error {
+	_, err := db.ModelContext(ctx, user).Returning("*").Insert()
+	var pgErr pg.Error
+	if errors.As(err, &pgErr) && pgErr.Field('C') == uniqueViolation {
+		return fmt.Errorf("%w: %s", ErrUserExists, user.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting user: %w", err)
+	}
+	return nil
+}
+
+// GetUser returns ErrUserNotFound if there is no user with the id
+func GetUser(ctx context.Context, db *pg.DB, id int64) (*User, error) {
+	user := &User{}
+	err := db.ModelContext(ctx, user).Where("id = ?", id).Select()
+	if err == pg.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrUserNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving user: %w", err)
+	}
+	return user, nil
+}
+
+// GetUserByName returns ErrUserNotFound if the tenant has no user of that name
+func GetUserByName(ctx context.Context, db *pg.DB, tenant string, name string) (*User, error) {
+	user := &User{}
+	err := db.ModelContext(ctx, user).Where("tenant = ?", tenant).Where("name = ?", name).Select()
+	if err == pg.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving user: %w", err)
+	}
+	return user, nil
+}
+
+func ListUsers(ctx context.Context, db *pg.DB) ([]User, error) {
+	var users []User
+	err := db.ModelContext(ctx, &users).Order("tenant", "name").Select()
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	return users, nil
+}
+
+// UpdateUser saves the profile and preferences of the user and reloads it.
+// It returns ErrUserNotFound if there is no user with its id
+func UpdateUser(ctx context.Context, db *pg.DB, user *User) error {
+	result, err := db.ModelContext(ctx, user).
+		// go-pg ignores Column once Set is used, so every column is set
+		Set("display_name = ?display_name").
+		Set("email = ?email").
+		Set("preferences = ?preferences").
+		Set("updated_at = now()").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		return fmt.Errorf("error updating user: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %d", ErrUserNotFound, user.ID)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user and revokes their API keys. It returns
+// ErrUserNotFound if there is no user with the id
+func DeleteUser(ctx context.Context, db *pg.DB, id int64) error {
+	return db.RunInTransaction(ctx, func(tx *pg.Tx) error {
+		_, err := tx.ModelContext(ctx, (*APIKey)(nil)).
+			Set("revoked_at = now()").
+			Where("user_id = ?", id).
+			Where("revoked_at IS NULL").
+			Update()
+		if err != nil {
+			return fmt.Errorf("error revoking the user's API keys: %w", err)
+		}
+
+		result, err := tx.ModelContext(ctx, (*User)(nil)).Where("id = ?", id).Delete()
+		if err != nil {
+			return fmt.Errorf("error deleting user: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("%w: %d", ErrUserNotFound, id)
+		}
+		return nil
+	})
+}
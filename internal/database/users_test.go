@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// queryRecorder records the queries sent to a database that cannot be reached
+type queryRecorder struct {
+	queries []string
+}
+
+func (r *queryRecorder) BeforeQuery(ctx context.Context, event *pg.QueryEvent) (context.Context, error) {
+	query, err := event.FormattedQuery()
+	if err != nil {
+		return ctx, err
+	}
+	r.queries = append(r.queries, string(query))
+	return ctx, nil
+}
+
+func (r *queryRecorder) AfterQuery(context.Context, *pg.QueryEvent) error {
+	return nil
+}
+
+func TestUpdateUserSetsEveryColumn(t *testing.T) {
+	db := pg.Connect(&pg.Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond, MaxRetries: 0})
+	defer db.Close()
+	recorder := &queryRecorder{}
+	db.AddQueryHook(recorder)
+
+	user := &User{ID: 7, DisplayName: "Ada", Email: "ada@example.com", Preferences: models.UserPreferences{ChatModel: "mistral"}}
+	_ = UpdateUser(context.Background(), db, user)
+
+	if len(recorder.queries) == 0 {
+		t.Fatal("no query was sent")
+	}
+	query := recorder.queries[0]
+	for _, want := range []string{`display_name = 'Ada'`, `email = 'ada@example.com'`, `preferences = '{"chat_model":"mistral"}'`, `updated_at = now()`} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %s does not contain %s", query, want)
+		}
+	}
+}
+
+// TestUpdateUserRoundTrip needs a database, named by TIMESCALE_ADDRESS and
+// TIMESCALE_DATABASE
+func TestUpdateUserRoundTrip(t *testing.T) {
+	if os.Getenv("TIMESCALE_ADDRESS") == "" {
+		t.Skip("TIMESCALE_ADDRESS is not set")
+	}
+	if _, err := config.Load(""); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	defer Close()
+	if err := Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db := Pool()
+
+	user := &User{Tenant: "test", Name: "update-round-trip-" + time.Now().Format("150405.000000")}
+	if err := InsertUser(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = DeleteUser(ctx, db, user.ID) })
+
+	user.DisplayName, user.Email = "Ada", "ada@example.com"
+	user.Preferences = models.UserPreferences{ChatModel: "mistral", SearchLimit: 10}
+	if err := UpdateUser(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := GetUser(ctx, db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.DisplayName != "Ada" || stored.Email != "ada@example.com" || stored.Preferences != user.Preferences {
+		t.Errorf("stored user %+v does not have the updated fields", stored)
+	}
+}
@@ -2,18 +2,25 @@ package fileprocessing
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"orchestrator/internal/config"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/resilience"
+	"strings"
+	"time"
 
 	"github.com/russross/blackfriday/v2"
 )
 
-func getCIDAsBytes(cid string) ([]byte, error) {
-	baseURL := "http://127.0.0.1:5001/api/v0/cat"
+var ipfsBreaker = resilience.NewCircuitBreaker("ipfs", 5, 30*time.Second)
 
-	u, err := url.Parse(baseURL)
+func getCIDAsBytes(ctx context.Context, cid string) ([]byte, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.Get().IPFS.URL, "/") + "/api/v0/cat")
 	if err != nil {
 		return nil, fmt.Errorf("error parsing URL: %w", err)
 	}
@@ -21,7 +28,29 @@ func getCIDAsBytes(cid string) ([]byte, error) {
 	q.Set("arg", cid)
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest("POST", u.String(), nil)
+	var body []byte
+	err = resilience.Do(ctx, ipfsBreaker, resilience.DefaultPolicy, func(ctx context.Context) error {
+		var err error
+		body, err = fetchIPFS(ctx, u.String())
+		return err
+	})
+	if err != nil {
+		metrics.IPFSFetchErrors.Inc()
+		return nil, err
+	}
+	metrics.IPFSFetchBytes.Observe(float64(len(body)))
+	return body, nil
+}
+
+// CheckIPFS verifies that the IPFS HTTP API answers, bypassing the circuit breaker
+func CheckIPFS(ctx context.Context) error {
+	_, err := fetchIPFS(ctx, strings.TrimSuffix(config.Get().IPFS.URL, "/")+"/api/v0/version")
+	return err
+}
+
+// fetchIPFS makes a single request against the IPFS HTTP API
+func fetchIPFS(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -35,7 +64,7 @@ func getCIDAsBytes(cid string) ([]byte, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, &resilience.StatusError{Service: "ipfs", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -47,10 +76,10 @@ func getCIDAsBytes(cid string) ([]byte, error) {
 }
 
 // TODO: Remove support for file types other than .text/.txt; parsing doesn't work at the moment for other file types
-func GetFileChunksFromCIDAsStrings(cid string, chunkSize int) ([]string, error) {
-	fileBytes, err := getCIDAsBytes(cid)
+func GetFileChunksFromCIDAsStrings(ctx context.Context, cid string, chunkSize int) ([]string, error) {
+	fileBytes, err := getCIDAsBytes(ctx, cid)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving file from CID: %v", err)
+		return nil, fmt.Errorf("error retrieving file from CID: %w", err)
 	}
 
 	// Identify file type
@@ -58,6 +87,7 @@ func GetFileChunksFromCIDAsStrings(cid string, chunkSize int) ([]string, error)
 	if err != nil {
 		return nil, fmt.Errorf("error identifying file type: %v", err)
 	}
+	slog.DebugContext(ctx, "Identified file type", "cid", cid, "type", fileType, "bytes", len(fileBytes))
 
 	var text string
 
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 
 	"os"
 	"path/filepath"
@@ -42,27 +42,20 @@ func IdentifyFileType(fileBytes []byte) (string, error) {
 
 	switch {
 	case strings.HasPrefix(mimeString, "application/pdf"):
-		fmt.Printf("\nDetected PDF file\n")
 		return ".pdf", nil
 	case strings.HasPrefix(mimeString, "text/plain"):
 		// Check if it looks like Markdown
 		if looksLikeMarkdown(fileBytes) {
-			fmt.Printf("\nDetected Markdown file\n")
 			return ".md", nil
 		}
-		fmt.Printf("\nDetected text file\n")
 		return ".txt", nil
 	case strings.HasPrefix(mimeString, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"):
-		fmt.Printf("\nDetected Word file DOCX\n")
 		return ".docx", nil
 	case strings.HasPrefix(mimeString, "application/msword"):
-		fmt.Printf("\nDetected Word file DOC\n")
 		return ".doc", nil
 	case strings.HasPrefix(mimeString, "text/markdown"):
-		fmt.Printf("\nDetected Markdown file\n")
 		return ".md", nil
 	default:
-		fmt.Printf("\nUnknown or unsupported file type: %s\n", mimeString)
 		return "", errors.New("unknown or unsupported file type: " + mimeString)
 	}
 }
@@ -84,9 +77,9 @@ func writeToFile(filename string, content string) {
 
 	err := os.WriteFile(filename, []byte(content), 0644)
 	if err != nil {
-		log.Printf("Error writing to file %s: %v", filename, err)
+		slog.Error("Error writing to file", "file", filename, "error", err)
 	} else {
-		log.Printf("Successfully wrote to file: %s", filename)
+		slog.Debug("Wrote to file", "file", filename)
 	}
 }
 
@@ -240,7 +233,6 @@ func ExtractTextFromDOCX(fileBytes []byte) (string, error) {
 }
 
 func SplitStringIntoStringArray(text string, chunkSize int) []string {
-	//fmt.Printf("SplitStringIntoStringArray\n")
 	var chunks []string
 	var currentChunk strings.Builder
 
@@ -262,7 +254,6 @@ func SplitStringIntoStringArray(text string, chunkSize int) []string {
 }
 
 func normalizeWhitespace(input string) string {
-	fmt.Printf("normalizeWhitespace\n")
 	// Replace multiple spaces with a single space
 	spaceNormalized := regexp.MustCompile(`\s+`).ReplaceAllString(input, " ")
 	// Ensure single newline between paragraphs
@@ -270,7 +261,6 @@ func normalizeWhitespace(input string) string {
 }
 
 func removeNonPrintableCharacters(input string) string {
-	//fmt.Printf("removeNonPrintableCharacters\n")
 	return strings.Map(func(r rune) rune {
 		if unicode.IsPrint(r) {
 			return r
@@ -280,7 +270,6 @@ func removeNonPrintableCharacters(input string) string {
 }
 
 func IdentifyAndReplaceCommonProblematicCharacters(input string) string {
-	//fmt.Printf("IdentifyAndReplaceCommonProblematicCharacters\n")
 	// Define a set of "safe" characters
 	safeSet := &unicode.RangeTable{
 		R16: []unicode.Range16{
@@ -320,7 +309,6 @@ func IdentifyAndReplaceCommonProblematicCharacters(input string) string {
 		}
 		return -1
 	}, cleaned)
-	//fmt.Printf("IdentifyAndReplaceCommonProblematicCharacters: %s\n", cleaned)
 	// Normalize remaining whitespace
 	re := regexp.MustCompile(`\s+`)
 	cleaned = re.ReplaceAllString(cleaned, " ")
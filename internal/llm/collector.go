@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"context"
+	"orchestrator/internal/models"
+	"sync"
+)
+
+type collectorKey struct{}
+
+// Collector gathers what happened while serving a request: trimmed prompts,
+// the prompt template versions used and model usage per stage. It is safe for
+// concurrent use by the branches of a multi-node query.
+type Collector struct {
+	mu             sync.Mutex
+	trimmed        []models.PromptReport
+	promptVersions map[string]string
+	usage          []models.StageUsage
+}
+
+// WithCollector returns a context whose pipeline stages report to the collector
+func WithCollector(ctx context.Context) (context.Context, *Collector) {
+	collector := &Collector{}
+	return context.WithValue(ctx, collectorKey{}, collector), collector
+}
+
+// collectorFrom returns the request's collector; methods on a nil collector do nothing
+func collectorFrom(ctx context.Context) *Collector {
+	collector, _ := ctx.Value(collectorKey{}).(*Collector)
+	return collector
+}
+
+func (c *Collector) addTrimmed(report models.PromptReport) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trimmed = append(c.trimmed, report)
+}
+
+func (c *Collector) addPromptVersion(name string, version string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.promptVersions == nil {
+		c.promptVersions = make(map[string]string)
+	}
+	c.promptVersions[name] = version
+}
+
+func (c *Collector) addUsage(stage Stage, model string, usage models.Usage) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.usage {
+		if c.usage[i].Stage == string(stage) && c.usage[i].Model == model {
+			c.usage[i].Add(usage)
+			return
+		}
+	}
+	c.usage = append(c.usage, models.StageUsage{Stage: string(stage), Model: model, Usage: usage})
+}
+
+// Usage returns the model usage of the request, nil if no model was called
+func (c *Collector) Usage() *models.UsageReport {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.usage) == 0 {
+		return nil
+	}
+	report := &models.UsageReport{Stages: append([]models.StageUsage(nil), c.usage...)}
+	for _, stage := range c.usage {
+		report.Total.Add(stage.Usage)
+	}
+	return report
+}
+
+// Trimmed returns the prompts that had to be trimmed, nil if none were
+func (c *Collector) Trimmed() []models.PromptReport {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]models.PromptReport(nil), c.trimmed...)
+}
+
+// PromptVersions returns the version of every prompt template used, keyed by name
+func (c *Collector) PromptVersions() map[string]string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions := make(map[string]string, len(c.promptVersions))
+	for name, version := range c.promptVersions {
+		versions[name] = version
+	}
+	return versions
+}
@@ -1,34 +1,59 @@
 package llm
 
 import (
+	"context"
 	"fmt"
+	"orchestrator/internal/auth"
 	"orchestrator/internal/database"
 	"orchestrator/internal/models"
+	"orchestrator/internal/tracing"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func QueryUserRequestForSimilarDocuments(request models.LLMRAGQueryRequest) (string, error) {
-	db, err := database.CreateDatabaseConnectionFromEnv()
+// QueryUserRequestForSimilarDocuments returns the nearest document chunks,
+// scored by cosine similarity to the request input
+func QueryUserRequestForSimilarDocuments(ctx context.Context, request models.LLMRAGQueryRequest) (_ []ContextChunk, _ []models.Source, err error) {
+	ctx, span := tracing.Start(ctx, "rag.retrieval",
+		attribute.String("rag.data_source", DataSourceDocuments),
+		attribute.Int("rag.search_limit", request.SearchLimit),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tenant, err := auth.TenantFromContext(ctx)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	defer db.Close()
-	var result strings.Builder
-	query_embedding, err := CreateEmbedding(request.Model, request.Input)
+	ctx, cancel := WithStageTimeout(ctx, StageRetrieval)
+	defer cancel()
+
+	db := database.Pool()
+	query_embedding, err := CreateEmbedding(ctx, request.EmbeddingModel, request.Input)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	similarDocuments, err := database.GetSimilaritySearchDocuments(db, query_embedding, request.SearchLimit)
+	similarDocuments, err := database.GetSimilaritySearchDocuments(ctx, db, tenant, query_embedding, request.SearchLimit)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
+	span.SetAttributes(attribute.Int("rag.results", len(similarDocuments)))
+
+	var chunks []ContextChunk
+	var sources []models.Source
 	for i, doc := range similarDocuments {
+		var result strings.Builder
 		result.WriteString(fmt.Sprintf("Document %d:\n", i+1))
 		result.WriteString(fmt.Sprintf("Collection Slug: %s\n", doc.CollectionSlug))
 		result.WriteString(fmt.Sprintf("CID: %s\n", doc.CID))
 		result.WriteString(fmt.Sprintf("Content: %s\n", doc.Content))
-		result.WriteString("\n")
+		chunks = append(chunks, ContextChunk{
+			Label:   fmt.Sprintf("document %d (%s)", i+1, doc.CID),
+			Content: result.String(),
+			Score:   1 - doc.Distance,
+		})
+		sources = append(sources, models.Source{Type: "document", Reference: doc.CID, CollectionSlug: doc.CollectionSlug})
 	}
 
-	return result.String(), nil
+	return chunks, sources, nil
 }
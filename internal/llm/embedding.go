@@ -2,77 +2,102 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
 	"orchestrator/internal/database"
 	"orchestrator/internal/fileprocessing"
 	"orchestrator/internal/models"
+	"orchestrator/internal/resilience"
+	"orchestrator/internal/tracing"
 
 	"github.com/pgvector/pgvector-go"
-	"github.com/tmc/langchaingo/llms/ollama"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateEmbedding(requestModel string, content string) (pgvector.Vector, error) {
-	model, err := ollama.New(ollama.WithModel(requestModel))
-	if err != nil {
-		return pgvector.Vector{}, fmt.Errorf("failed to create ollama model: %w", err)
-	}
+func CreateEmbedding(ctx context.Context, requestModel string, content string) (_ pgvector.Vector, err error) {
+	ctx, span := tracing.Start(ctx, "llm.embedding",
+		attribute.String("llm.model", requestModel),
+		attribute.Int("llm.input_bytes", len(content)),
+	)
+	defer func() { tracing.End(span, err) }()
 
-	twoDimensionalEmbedding, err := model.CreateEmbedding(context.Background(), []string{content})
+	ctx, cancel := WithStageTimeout(ctx, StageEmbedding)
+	defer cancel()
+
+	embedding, err := requestOllamaEmbedding(ctx, requestModel, content)
 	if err != nil {
 		return pgvector.Vector{}, fmt.Errorf("failed to create embedding: %w", err)
 	}
-
-	oneDimensionalEmbedding := make([]float32, 0, len(twoDimensionalEmbedding)*len(twoDimensionalEmbedding[0]))
-	for _, row := range twoDimensionalEmbedding {
-		oneDimensionalEmbedding = append(oneDimensionalEmbedding, row...)
-	}
-	return pgvector.NewVector(oneDimensionalEmbedding), nil
+	return pgvector.NewVector(embedding), nil
 }
 
-func ProcessDocumentEmbeddingsInChunks(request models.DocumentEmbeddingsRequest) error {
-	db, err := database.CreateDatabaseConnectionFromEnv()
+// ProcessDocumentEmbeddingsInChunks stores the document for the tenant of the caller
+func ProcessDocumentEmbeddingsInChunks(ctx context.Context, request models.DocumentEmbeddingsRequest) error {
+	tenant, err := auth.TenantFromContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error creating database connection: %w", err)
+		return err
 	}
-	defer db.Close()
-	content, err := fileprocessing.GetFileChunksFromCIDAsStrings(request.CID, 1000)
+	ctx, cancel := WithStageTimeout(ctx, StageIngestion)
+	defer cancel()
+
+	db := database.Pool()
+	content, err := fileprocessing.GetFileChunksFromCIDAsStrings(ctx, request.CID, config.Get().Ingestion.ChunkSize)
 
 	if err != nil {
 		return fmt.Errorf("error getting content for CID: %w", err)
 	}
 
+	var failed int
+	var firstErr error
 	for i, chunk := range content {
+		if ctx.Err() != nil {
+			return fmt.Errorf("document embedding stopped after %d of %d chunks: %w", i, len(content), ctx.Err())
+		}
 		if chunk == "" {
-			fmt.Printf("Warning: Empty chunk at index %d\n", i)
+			slog.WarnContext(ctx, "Skipping empty chunk", "cid", request.CID, "chunk", i)
 			continue
 		}
 
-		embedding, err := CreateEmbedding(request.Model, chunk)
-		if err != nil {
-			fmt.Printf("Error creating embedding for chunk %d: %v\n", i, err)
-			continue
+		embedding, err := CreateEmbedding(ctx, request.Model, chunk)
+		if errors.Is(err, resilience.ErrCircuitOpen) {
+			return fmt.Errorf("document embedding stopped after %d of %d chunks: %w", i, len(content), err)
+		}
+		if err == nil {
+			err = database.InsertDocumentEmbedding(ctx, db, tenant, request, chunk, embedding)
 		}
-
-		err = database.InsertDocumentEmbedding(db, request, chunk, embedding)
 		if err != nil {
-			fmt.Printf("Error inserting document embedding for chunk %d: %v\n", i, err)
-			continue
+			slog.ErrorContext(ctx, "Error embedding chunk", "cid", request.CID, "chunk", i, "error", err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
 		}
 	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to embed %d of %d chunks of %s: %w", failed, len(content), request.CID, firstErr)
+	}
 	return nil
 }
 
-func ProcessRowEmbeddings(request models.RowEmbeddingsRequest) error {
-	row, err := database.GetRowAsAString(request)
+func ProcessRowEmbeddings(ctx context.Context, request models.RowEmbeddingsRequest) error {
+	ctx, cancel := WithStageTimeout(ctx, StageIngestion)
+	defer cancel()
+
+	db := database.Pool()
+	row, err := database.GetRowAsAString(ctx, db, request)
 
 	if err != nil {
 		return err
 	}
 
-	embedding, err := CreateEmbedding(request.Model, row)
+	embedding, err := CreateEmbedding(ctx, request.Model, row)
 	if err != nil {
 		return err
 	}
 
-	return database.InsertRowEmbedding(request, embedding)
+	return database.InsertRowEmbedding(ctx, db, request, embedding)
 }
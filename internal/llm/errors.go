@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"orchestrator/internal/resilience"
+)
+
+var (
+	// ErrInvalidInput is returned for requests the pipeline cannot run as given
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrModelNotFound is returned when Ollama does not have the requested model
+	ErrModelNotFound = errors.New("model not found")
+	// ErrUpstreamUnavailable is returned when Ollama cannot be reached or keeps failing
+	ErrUpstreamUnavailable = errors.New("model server unavailable")
+	// ErrSQLRejected is returned when generated SQL fails the safety checks or the database refuses to run it
+	ErrSQLRejected = errors.New("generated SQL query was rejected")
+)
+
+// ollamaError classifies the error of an Ollama call that failed after retries
+func ollamaError(model string, err error) error {
+	var statusErr *resilience.StatusError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s: %w", ErrModelNotFound, model, err)
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	case errors.Is(err, resilience.ErrCircuitOpen), resilience.IsRetryable(err):
+		return fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"orchestrator/internal/config"
+	"orchestrator/internal/resilience"
+	"slices"
+	"strings"
+)
+
+// CheckModels verifies that Ollama is up and has pulled the models. It
+// bypasses the circuit breaker, since probes must see Ollama's actual state
+func CheckModels(ctx context.Context, names ...string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(config.Get().Ollama.URL, "/")+"/api/tags", nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &resilience.StatusError{Service: "ollama", StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+
+	var tags OllamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return fmt.Errorf("error decoding model list: %w", err)
+	}
+	var available []string
+	for _, model := range tags.Models {
+		available = append(available, model.Name, strings.TrimSuffix(model.Name, ":latest"))
+	}
+	var missing []string
+	for _, name := range names {
+		if !slices.Contains(available, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("models not pulled: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
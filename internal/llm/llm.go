@@ -1,81 +1,66 @@
 package llm
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
 	"orchestrator/internal/database"
 	"orchestrator/internal/models"
+	"orchestrator/internal/prompts"
 	"strings"
 )
 
-func QueryOllama(model string, chatMessages []OllamaChatMessage) (string, error) {
-	url := "http://localhost:11434/api/chat"
+func QueryOllama(ctx context.Context, model string, chatMessages []OllamaChatMessage, options models.GenerationOptions) (string, error) {
+	return sendOllamaChat(ctx, OllamaRequest{
+		Model:    model,
+		Messages: chatMessages,
+		Format:   options.Format,
+		Options:  ollamaOptions(options),
+	})
+}
 
-	jsonQuery, err := json.Marshal(OllamaRequest{
+// QueryOllamaStructured constrains the model output to the given JSON schema
+func QueryOllamaStructured(ctx context.Context, model string, chatMessages []OllamaChatMessage, schema json.RawMessage, options models.GenerationOptions) (string, error) {
+	return sendOllamaChat(ctx, OllamaRequest{
 		Model:    model,
 		Messages: chatMessages,
+		Format:   schema,
+		Options:  ollamaOptions(options),
 	})
-	if err != nil {
-		return "", fmt.Errorf("error marshaling JSON: %w", err)
-	}
+}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonQuery))
+// StartConversation returns conversationID once it is known to be a
+// conversation of the caller's tenant, or starts a new conversation with the
+// title when conversationID is 0
+func StartConversation(ctx context.Context, conversationID int64, title string) (int64, error) {
+	tenant, err := auth.TenantFromContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("error sending request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	scanner := bufio.NewScanner(resp.Body)
-	var fullResponse strings.Builder
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
-
-		var ollamaResponse OllamaResponse
-		err = json.Unmarshal([]byte(line), &ollamaResponse)
-		if err != nil {
-			fmt.Printf("Error unmarshaling JSON: %v\n", err)
-			continue
-		}
-
-		if ollamaResponse.Message.Content != "" {
-			fullResponse.WriteString(ollamaResponse.Message.Content)
-		}
-
-		if ollamaResponse.Done {
-			break
-		}
+		return 0, err
 	}
+	db := database.Pool()
 
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("error reading response: %w", err)
-	}
-
-	if fullResponse.Len() == 0 {
-		log.Print("No valid response found in the API output")
-		return "", nil
+	conversation, err := database.GetOrCreateConversation(ctx, db, tenant, conversationID, truncateString(title, 50))
+	if err != nil {
+		return 0, err
 	}
-
-	return fullResponse.String(), nil
+	return conversation.ID, nil
 }
 
-func ProcessLLMSimpleQuery(request models.LLMSimpleQueryRequest) (string, error) {
-	db, err := database.CreateDatabaseConnectionFromEnv()
+func ProcessLLMSimpleQuery(ctx context.Context, request models.LLMSimpleQueryRequest) (string, error) {
+	tenant, err := auth.TenantFromContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("error creating database connection: %w", err)
+		return "", err
 	}
-	defer db.Close()
+	db := database.Pool()
 
 	var conversationHistory []OllamaChatMessage
 	if request.ConversationID != 0 {
-		messages, err := database.GetRecentMessages(db, request.ConversationID, 10)
+		if _, err := database.GetConversation(ctx, db, tenant, request.ConversationID); err != nil {
+			return "", err
+		}
+		messages, err := database.GetRecentMessages(ctx, db, tenant, request.ConversationID, config.Get().Chat.HistoryLimit)
 		if err != nil {
 			return "", fmt.Errorf("error retrieving conversation history: %w", err)
 		}
@@ -88,20 +73,30 @@ func ProcessLLMSimpleQuery(request models.LLMSimpleQueryRequest) (string, error)
 		}
 	}
 
-	conversationHistory = append(conversationHistory, OllamaChatMessage{
-		Role:    "user",
-		Content: request.Input,
+	chatCtx, cancel := WithStageTimeout(ctx, StageChat)
+	defer cancel()
+	system, question, err := renderPrompt(ctx, prompts.Chat, prompts.Data{Question: request.Input})
+	if err != nil {
+		return "", err
+	}
+	options := ResolveOptions(StageChat, request.Options)
+	messages, err := buildPrompt(chatCtx, StageChat, request.Model, options, PromptBuilder{
+		Instructions: []OllamaChatMessage{system},
+		History:      conversationHistory,
+		Question:     question,
 	})
-
-	response, err := QueryOllama(request.Model, conversationHistory)
+	if err != nil {
+		return "", err
+	}
+	response, err := QueryOllama(chatCtx, request.Model, messages, options)
 	if err != nil {
 		return "", fmt.Errorf("error querying Ollama: %w", err)
 	}
 
 	title := fmt.Sprintf("Simple Query: %s", truncateString(request.Input, 50))
-	err = database.SaveMessages(db, request.ConversationID, []database.Message{
+	err = database.SaveMessages(ctx, db, tenant, request.ConversationID, []database.Message{
 		{Role: "user", Content: request.Input},
-		{Role: "assistant", Content: strings.ReplaceAll(response, "\n", "\\n")},
+		{Role: "assistant", Content: strings.ReplaceAll(response, "\n", "\\n"), Usage: collectorFrom(ctx).Usage()},
 	}, title)
 	if err != nil {
 		return "", fmt.Errorf("error saving conversation: %w", err)
@@ -0,0 +1,194 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"orchestrator/internal/config"
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/models"
+	"orchestrator/internal/resilience"
+	"orchestrator/internal/tracing"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Shared by chat and embedding calls, since both fail together when Ollama is down
+var ollamaBreaker = resilience.NewCircuitBreaker("ollama", 5, 30*time.Second)
+
+// sendOllamaChat sends a chat request and accounts its usage to the stage of ctx
+func sendOllamaChat(ctx context.Context, request OllamaRequest) (_ string, err error) {
+	jsonQuery, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling JSON: %w", err)
+	}
+
+	var response string
+	var usage models.Usage
+	stage := string(stageFrom(ctx))
+	ctx, span := tracing.Start(ctx, "ollama.chat",
+		attribute.String("llm.model", request.Model),
+		attribute.String("llm.stage", stage),
+		attribute.Int("llm.messages", len(request.Messages)),
+	)
+	defer func() { tracing.End(span, err) }()
+	start := time.Now()
+	handle := tokenHandlerFrom(ctx)
+	streamed := false
+	onToken := func(token string) {
+		if handle != nil {
+			streamed = true
+			handle(token)
+		}
+	}
+	err = resilience.Do(ctx, ollamaBreaker, resilience.DefaultPolicy, func(ctx context.Context) error {
+		var err error
+		response, usage, err = streamOllamaChat(ctx, jsonQuery, onToken)
+		if err != nil && streamed {
+			return interruptedStream(err)
+		}
+		return err
+	})
+	metrics.LLMCallDuration.WithLabelValues(request.Model, stage).Observe(time.Since(start).Seconds())
+	metrics.LLMCalls.WithLabelValues(request.Model, stage, metrics.Outcome(err)).Inc()
+	if err != nil {
+		return "", ollamaError(request.Model, err)
+	}
+	span.SetAttributes(
+		attribute.Int("llm.prompt_tokens", usage.PromptTokens),
+		attribute.Int("llm.completion_tokens", usage.CompletionTokens),
+		attribute.Int64("llm.load_duration_ms", usage.LoadDurationMs),
+	)
+	metrics.LLMTokens.WithLabelValues(request.Model, stage, "prompt").Add(float64(usage.PromptTokens))
+	metrics.LLMTokens.WithLabelValues(request.Model, stage, "completion").Add(float64(usage.CompletionTokens))
+	collectorFrom(ctx).addUsage(stageFrom(ctx), request.Model, usage)
+	return response, nil
+}
+
+// streamOllamaChat makes a single /api/chat call and concatenates the streamed
+// message, passing each piece to onToken. Usage is read from the final line of the stream.
+func streamOllamaChat(ctx context.Context, jsonQuery []byte, onToken func(string)) (string, models.Usage, error) {
+	var usage models.Usage
+	resp, err := postOllama(ctx, "/api/chat", jsonQuery)
+	if err != nil {
+		return "", usage, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	var fullResponse strings.Builder
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		var ollamaResponse OllamaResponse
+		err = json.Unmarshal([]byte(line), &ollamaResponse)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping malformed Ollama response line", "error", err)
+			continue
+		}
+
+		if ollamaResponse.Error != "" {
+			return "", usage, fmt.Errorf("ollama error: %s", ollamaResponse.Error)
+		}
+
+		if ollamaResponse.Message.Content != "" {
+			fullResponse.WriteString(ollamaResponse.Message.Content)
+			onToken(ollamaResponse.Message.Content)
+		}
+
+		if ollamaResponse.Done {
+			usage = ollamaResponse.Usage()
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", usage, fmt.Errorf("error reading response: %w", err)
+	}
+
+	if fullResponse.Len() == 0 {
+		slog.WarnContext(ctx, "Ollama returned an empty response")
+		return "", usage, nil
+	}
+
+	return fullResponse.String(), usage, nil
+}
+
+// requestOllamaEmbedding makes a single /api/embeddings call
+func requestOllamaEmbedding(ctx context.Context, model string, content string) ([]float32, error) {
+	jsonQuery, err := json.Marshal(OllamaEmbeddingRequest{Model: model, Prompt: content})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %w", err)
+	}
+
+	var embedding []float32
+	start := time.Now()
+	defer func() {
+		metrics.EmbeddingDuration.WithLabelValues(model).Observe(time.Since(start).Seconds())
+		metrics.Embeddings.WithLabelValues(model, metrics.Outcome(err)).Inc()
+		if err == nil {
+			metrics.EmbeddingInputBytes.WithLabelValues(model).Add(float64(len(content)))
+		}
+	}()
+	err = resilience.Do(ctx, ollamaBreaker, resilience.DefaultPolicy, func(ctx context.Context) error {
+		resp, err := postOllama(ctx, "/api/embeddings", jsonQuery)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var embeddingResponse OllamaEmbeddingResponse
+		if err := json.NewDecoder(resp.Body).Decode(&embeddingResponse); err != nil {
+			return fmt.Errorf("error decoding embedding response: %w", err)
+		}
+		embedding = embeddingResponse.Embedding
+		return nil
+	})
+	if err != nil {
+		return nil, ollamaError(model, err)
+	}
+	if len(embedding) == 0 {
+		err = fmt.Errorf("ollama returned an empty embedding for model %s", model)
+		return nil, err
+	}
+	return embedding, nil
+}
+
+// postOllama sends a request and turns non-200 responses into a StatusError
+func postOllama(ctx context.Context, path string, jsonQuery []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(config.Get().Ollama.URL, "/")+path, bytes.NewReader(jsonQuery))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(body))
+		var errorResponse struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &errorResponse) == nil && errorResponse.Error != "" {
+			message = errorResponse.Error
+		}
+		return nil, &resilience.StatusError{Service: "ollama", StatusCode: resp.StatusCode, Message: message}
+	}
+	return resp, nil
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"fmt"
+	"orchestrator/internal/config"
+	"orchestrator/internal/models"
+	"slices"
+	"strings"
+)
+
+// Seed used by stages that must give the same answer for the same input
+const DeterministicSeed = 42
+
+// Stages callers may pass options for in stage_options
+var ConfigurableStages = []Stage{StagePlan, StageSQL, StageStep, StageSynthesis}
+
+// DefaultStageOptions make planning and SQL generation deterministic while
+// leaving room for phrasing in the answers. They can be overridden per
+// deployment with llm.stage_options in the config or ORCHESTRATOR_STAGE_OPTIONS,
+// a JSON object keyed by stage, e.g. {"synthesis": {"temperature": 0.4}}
+var DefaultStageOptions = map[Stage]models.GenerationOptions{
+	StagePlan:      {Temperature: ptr(0.0), Seed: ptr(DeterministicSeed)},
+	StageSQL:       {Temperature: ptr(0.0), Seed: ptr(DeterministicSeed)},
+	StageStep:      {Temperature: ptr(0.2)},
+	StageSynthesis: {Temperature: ptr(0.7)},
+}
+
+// ResolveOptions merges the stage defaults with caller overrides; later
+// overrides win field by field
+func ResolveOptions(stage Stage, overrides ...*models.GenerationOptions) models.GenerationOptions {
+	options := DefaultStageOptions[stage]
+	if configured, ok := config.Get().LLM.StageOptions[string(stage)]; ok {
+		options = mergeOptions(options, &configured)
+	}
+	for _, override := range overrides {
+		options = mergeOptions(options, override)
+	}
+	return options
+}
+
+// stageOverride returns the caller's options for a stage of a RAG request, if any
+func stageOverride(request models.LLMRAGQueryRequest, stage Stage) *models.GenerationOptions {
+	options, ok := request.StageOptions[string(stage)]
+	if !ok {
+		return nil
+	}
+	return &options
+}
+
+// ValidateStageOptions rejects options for stages that do not exist
+func ValidateStageOptions(stageOptions map[string]models.GenerationOptions) error {
+	for stage := range stageOptions {
+		if !slices.Contains(ConfigurableStages, Stage(stage)) {
+			names := make([]string, len(ConfigurableStages))
+			for i, s := range ConfigurableStages {
+				names[i] = string(s)
+			}
+			return fmt.Errorf("%w: unknown stage %q in stage_options, expected one of %s", ErrInvalidInput, stage, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+func mergeOptions(base models.GenerationOptions, override *models.GenerationOptions) models.GenerationOptions {
+	if override == nil {
+		return base
+	}
+	if override.Temperature != nil {
+		base.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		base.TopP = override.TopP
+	}
+	if override.NumCtx != nil {
+		base.NumCtx = override.NumCtx
+	}
+	if override.Seed != nil {
+		base.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		base.Stop = override.Stop
+	}
+	if override.Format != nil {
+		base.Format = override.Format
+	}
+	return base
+}
+
+// ollamaOptions converts generation options into Ollama's options field
+func ollamaOptions(options models.GenerationOptions) *OllamaOptions {
+	if options.Temperature == nil && options.TopP == nil && options.NumCtx == nil && options.Seed == nil && options.Stop == nil {
+		return nil
+	}
+	return &OllamaOptions{
+		Temperature: options.Temperature,
+		TopP:        options.TopP,
+		NumCtx:      options.NumCtx,
+		Seed:        options.Seed,
+		Stop:        options.Stop,
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,263 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"orchestrator/internal/models"
+	"orchestrator/internal/prompts"
+	"orchestrator/internal/tracing"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	DefaultMinSubQuestions = 2
+	DefaultMaxSubQuestions = 5
+	// Upper bound accepted from callers, to keep fan-out to Ollama in check
+	MaxSubQuestionsLimit = 10
+	// Number of times the model is asked for a plan before giving up
+	MaxPlanningAttempts = 3
+)
+
+// Data sources a plan step can be answered from
+const (
+	DataSourceSQL       = "sql"
+	DataSourceDocuments = "documents"
+	DataSourceRows      = "rows"
+)
+
+var AllDataSources = []string{DataSourceSQL, DataSourceDocuments, DataSourceRows}
+
+var ErrPlanningFailed = errors.New("query planning failed")
+
+// PlanStep is a single sub-question of a query plan
+type PlanStep struct {
+	ID         string   `json:"id"`
+	Question   string   `json:"question"`
+	DataSource string   `json:"data_source"`
+	DependsOn  []string `json:"depends_on"`
+}
+
+// QueryPlan is a small DAG of steps; the model is asked to produce it as JSON
+type QueryPlan struct {
+	Steps []PlanStep `json:"steps"`
+}
+
+// SubQuestionBounds returns the min/max number of plan steps for a request,
+// falling back to the package defaults for values that were not supplied
+func SubQuestionBounds(request models.LLMRAGQueryRequest) (int, int, error) {
+	minQuestions := request.MinSubQuestions
+	if minQuestions <= 0 {
+		minQuestions = DefaultMinSubQuestions
+	}
+	maxQuestions := request.MaxSubQuestions
+	if maxQuestions <= 0 {
+		maxQuestions = max(DefaultMaxSubQuestions, minQuestions)
+	}
+
+	if maxQuestions > MaxSubQuestionsLimit {
+		return 0, 0, fmt.Errorf("%w: max_sub_questions must be at most %d, got %d", ErrInvalidInput, MaxSubQuestionsLimit, maxQuestions)
+	}
+	if minQuestions > maxQuestions {
+		return 0, 0, fmt.Errorf("%w: min_sub_questions (%d) must not exceed max_sub_questions (%d)", ErrInvalidInput, minQuestions, maxQuestions)
+	}
+	return minQuestions, maxQuestions, nil
+}
+
+// AllowedDataSources restricts the planner to the data sources requested by the caller
+func AllowedDataSources(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return AllDataSources, nil
+	}
+	var allowed []string
+	for _, source := range requested {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if !slices.Contains(AllDataSources, source) {
+			return nil, fmt.Errorf("%w: unknown data source %q, expected one of %s", ErrInvalidInput, source, strings.Join(AllDataSources, ", "))
+		}
+		if !slices.Contains(allowed, source) {
+			allowed = append(allowed, source)
+		}
+	}
+	return allowed, nil
+}
+
+// planSchema builds the JSON schema passed to Ollama's format field
+func planSchema(minSteps, maxSteps int, dataSources []string) json.RawMessage {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"steps": map[string]any{
+				"type":     "array",
+				"minItems": minSteps,
+				"maxItems": maxSteps,
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"id":          map[string]any{"type": "string"},
+						"question":    map[string]any{"type": "string"},
+						"data_source": map[string]any{"type": "string", "enum": dataSources},
+						"depends_on":  map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					},
+					"required": []string{"id", "question", "data_source", "depends_on"},
+				},
+			},
+		},
+		"required": []string{"steps"},
+	}
+	// Marshalling a map of plain values cannot fail
+	raw, _ := json.Marshal(schema)
+	return raw
+}
+
+// ParseQueryPlan decodes a plan produced by the model and validates it
+func ParseQueryPlan(response string, minSteps, maxSteps int, dataSources []string) (QueryPlan, error) {
+	var plan QueryPlan
+	if err := json.Unmarshal([]byte(strings.TrimSpace(response)), &plan); err != nil {
+		return QueryPlan{}, fmt.Errorf("response is not a valid JSON object: %w", err)
+	}
+
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		step.ID = strings.TrimSpace(step.ID)
+		step.Question = strings.TrimSpace(step.Question)
+		step.DataSource = strings.ToLower(strings.TrimSpace(step.DataSource))
+	}
+
+	if err := plan.Validate(minSteps, maxSteps, dataSources); err != nil {
+		return QueryPlan{}, err
+	}
+	return plan, nil
+}
+
+// Validate checks the step count, step fields and that dependencies form a DAG
+func (p QueryPlan) Validate(minSteps, maxSteps int, dataSources []string) error {
+	if len(p.Steps) < minSteps || len(p.Steps) > maxSteps {
+		return fmt.Errorf("expected between %d and %d steps, got %d", minSteps, maxSteps, len(p.Steps))
+	}
+
+	ids := make(map[string]bool, len(p.Steps))
+	for _, step := range p.Steps {
+		if step.ID == "" {
+			return errors.New("every step needs a non-empty id")
+		}
+		if ids[step.ID] {
+			return fmt.Errorf("duplicate step id %q", step.ID)
+		}
+		ids[step.ID] = true
+		if step.Question == "" {
+			return fmt.Errorf("step %q has an empty question", step.ID)
+		}
+		if !slices.Contains(dataSources, step.DataSource) {
+			return fmt.Errorf("step %q uses data source %q, expected one of %s", step.ID, step.DataSource, strings.Join(dataSources, ", "))
+		}
+	}
+
+	for _, step := range p.Steps {
+		for _, dep := range step.DependsOn {
+			if dep == step.ID {
+				return fmt.Errorf("step %q depends on itself", step.ID)
+			}
+			if !ids[dep] {
+				return fmt.Errorf("step %q depends on unknown step %q", step.ID, dep)
+			}
+		}
+	}
+
+	if _, err := p.executionOrder(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// executionOrder returns step indexes in dependency order (Kahn's algorithm)
+func (p QueryPlan) executionOrder() ([]int, error) {
+	index := make(map[string]int, len(p.Steps))
+	for i, step := range p.Steps {
+		index[step.ID] = i
+	}
+
+	inDegree := make([]int, len(p.Steps))
+	dependents := make([][]int, len(p.Steps))
+	for i, step := range p.Steps {
+		for _, dep := range step.DependsOn {
+			inDegree[i]++
+			dependents[index[dep]] = append(dependents[index[dep]], i)
+		}
+	}
+
+	var queue, order []int
+	for i, degree := range inDegree {
+		if degree == 0 {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		order = append(order, current)
+		for _, next := range dependents[current] {
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	if len(order) != len(p.Steps) {
+		return nil, errors.New("step dependencies contain a cycle")
+	}
+	return order, nil
+}
+
+// GenerateQueryPlan asks the model for a plan, feeding validation errors back
+// to the model and retrying on invalid output
+func GenerateQueryPlan(ctx context.Context, model string, input string, minSteps, maxSteps int, dataSources []string, options models.GenerationOptions) (_ QueryPlan, err error) {
+	ctx, span := tracing.Start(ctx, "rag.plan",
+		attribute.String("llm.model", model),
+		attribute.Int("rag.min_steps", minSteps),
+		attribute.Int("rag.max_steps", maxSteps),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	system, user, err := renderPrompt(ctx, prompts.Planner, prompts.Data{
+		Question:    input,
+		MinSteps:    minSteps,
+		MaxSteps:    maxSteps,
+		DataSources: dataSources,
+	})
+	if err != nil {
+		return QueryPlan{}, err
+	}
+	messages := []OllamaChatMessage{system, user}
+	schema := planSchema(minSteps, maxSteps, dataSources)
+
+	ctx, cancel := WithStageTimeout(ctx, StagePlan)
+	defer cancel()
+
+	var lastErr error
+	for attempt := 1; attempt <= MaxPlanningAttempts; attempt++ {
+		response, err := QueryOllamaStructured(ctx, model, messages, schema, options)
+		if err != nil {
+			return QueryPlan{}, fmt.Errorf("%w: %w", ErrPlanningFailed, err)
+		}
+
+		plan, err := ParseQueryPlan(response, minSteps, maxSteps, dataSources)
+		if err == nil {
+			span.SetAttributes(attribute.Int("rag.attempts", attempt), attribute.Int("rag.steps", len(plan.Steps)))
+			return plan, nil
+		}
+		lastErr = err
+
+		messages = append(messages,
+			OllamaChatMessage{Role: "assistant", Content: response},
+			OllamaChatMessage{Role: "user", Content: fmt.Sprintf("Your previous response was invalid: %v. Reply again with only the JSON object.", err)},
+		)
+	}
+
+	return QueryPlan{}, fmt.Errorf("%w after %d attempts: %w", ErrPlanningFailed, MaxPlanningAttempts, lastErr)
+}
@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"errors"
+	"orchestrator/internal/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseQueryPlan(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		minSteps    int
+		maxSteps    int
+		dataSources []string
+		// Substring of the error, empty when the plan is valid
+		wantErr string
+	}{
+		{
+			name:     "valid plan",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql", "depends_on": []}, {"id": "b", "question": "q2", "data_source": "rows", "depends_on": ["a"]}]}`,
+			minSteps: 1,
+			maxSteps: 3,
+		},
+		{
+			name:     "fields are trimmed and data sources lowercased",
+			response: ` {"steps": [{"id": " a ", "question": " q1 ", "data_source": " SQL ", "depends_on": []}]} `,
+			minSteps: 1,
+			maxSteps: 1,
+		},
+		{
+			name:     "not JSON",
+			response: `steps: a, b`,
+			minSteps: 1,
+			maxSteps: 3,
+			wantErr:  "not a valid JSON object",
+		},
+		{
+			name:     "too few steps",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql", "depends_on": []}]}`,
+			minSteps: 2,
+			maxSteps: 3,
+			wantErr:  "expected between 2 and 3 steps, got 1",
+		},
+		{
+			name:     "too many steps",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql"}, {"id": "b", "question": "q2", "data_source": "sql"}, {"id": "c", "question": "q3", "data_source": "sql"}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  "expected between 1 and 2 steps, got 3",
+		},
+		{
+			name:     "no steps",
+			response: `{}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  "got 0",
+		},
+		{
+			name:     "empty id",
+			response: `{"steps": [{"id": " ", "question": "q1", "data_source": "sql"}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  "non-empty id",
+		},
+		{
+			name:     "duplicate id",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql"}, {"id": "a", "question": "q2", "data_source": "sql"}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  `duplicate step id "a"`,
+		},
+		{
+			name:     "empty question",
+			response: `{"steps": [{"id": "a", "question": "", "data_source": "sql"}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  "empty question",
+		},
+		{
+			name:        "data source not allowed",
+			response:    `{"steps": [{"id": "a", "question": "q1", "data_source": "sql"}]}`,
+			minSteps:    1,
+			maxSteps:    2,
+			dataSources: []string{DataSourceDocuments},
+			wantErr:     `uses data source "sql"`,
+		},
+		{
+			name:     "depends on itself",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql", "depends_on": ["a"]}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  "depends on itself",
+		},
+		{
+			name:     "depends on unknown step",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql", "depends_on": ["z"]}]}`,
+			minSteps: 1,
+			maxSteps: 2,
+			wantErr:  `unknown step "z"`,
+		},
+		{
+			name:     "two-step cycle",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql", "depends_on": ["b"]}, {"id": "b", "question": "q2", "data_source": "sql", "depends_on": ["a"]}]}`,
+			minSteps: 1,
+			maxSteps: 3,
+			wantErr:  "cycle",
+		},
+		{
+			name:     "cycle behind an independent step",
+			response: `{"steps": [{"id": "a", "question": "q1", "data_source": "sql"}, {"id": "b", "question": "q2", "data_source": "sql", "depends_on": ["a", "d"]}, {"id": "c", "question": "q3", "data_source": "sql", "depends_on": ["b"]}, {"id": "d", "question": "q4", "data_source": "sql", "depends_on": ["c"]}]}`,
+			minSteps: 1,
+			maxSteps: 5,
+			wantErr:  "cycle",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataSources := test.dataSources
+			if dataSources == nil {
+				dataSources = AllDataSources
+			}
+			plan, err := ParseQueryPlan(test.response, test.minSteps, test.maxSteps, dataSources)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				for _, step := range plan.Steps {
+					if step.ID != strings.TrimSpace(step.ID) || step.DataSource != strings.ToLower(step.DataSource) {
+						t.Errorf("step %+v was not normalized", step)
+					}
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecutionOrder(t *testing.T) {
+	plan := QueryPlan{Steps: []PlanStep{
+		{ID: "c", DependsOn: []string{"a", "b"}},
+		{ID: "b", DependsOn: []string{"a"}},
+		{ID: "a"},
+	}}
+	order, err := plan.executionOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, i := range order {
+		ids = append(ids, plan.Steps[i].ID)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(ids, want) {
+		t.Errorf("got order %v, want %v", ids, want)
+	}
+}
+
+func TestSubQuestionBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		wantMin  int
+		wantMax  int
+		wantErr  bool
+	}{
+		{name: "defaults", wantMin: DefaultMinSubQuestions, wantMax: DefaultMaxSubQuestions},
+		{name: "explicit", min: 1, max: 3, wantMin: 1, wantMax: 3},
+		{name: "maximum follows a large minimum", min: 7, wantMin: 7, wantMax: 7},
+		{name: "minimum above maximum", min: 4, max: 3, wantErr: true},
+		{name: "maximum above the limit", max: MaxSubQuestionsLimit + 1, wantErr: true},
+		{name: "minimum above the limit", min: MaxSubQuestionsLimit + 1, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotMin, gotMax, err := SubQuestionBounds(models.LLMRAGQueryRequest{MinSubQuestions: test.min, MaxSubQuestions: test.max})
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidInput) {
+					t.Fatalf("got error %v, want ErrInvalidInput", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotMin != test.wantMin || gotMax != test.wantMax {
+				t.Errorf("got bounds %d-%d, want %d-%d", gotMin, gotMax, test.wantMin, test.wantMax)
+			}
+		})
+	}
+}
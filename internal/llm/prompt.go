@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"orchestrator/internal/models"
+	"orchestrator/internal/prompts"
+	"sort"
+	"strings"
+)
+
+var ErrPromptTooLarge = errors.New("prompt does not fit the model's context window")
+
+// ContextChunk is a piece of retrieved data; chunks with the lowest score are
+// dropped first when the prompt is over budget
+type ContextChunk struct {
+	Label   string
+	Content string
+	Score   float64
+}
+
+// PromptBuilder assembles a prompt that fits a token budget. Instructions and
+// the question are always included; history is trimmed oldest first and
+// context lowest score first.
+type PromptBuilder struct {
+	Instructions []OllamaChatMessage
+	// Oldest message first
+	History       []OllamaChatMessage
+	ContextHeader string
+	Context       []ContextChunk
+	Question      OllamaChatMessage
+}
+
+// Build fits the prompt into the context window, keeping a quarter of it free
+// for the response. History may use at most a quarter of what is left after
+// instructions and question when there is context competing for the space.
+func (b PromptBuilder) Build(contextWindow int) ([]OllamaChatMessage, models.PromptReport, error) {
+	report := models.PromptReport{ContextWindow: contextWindow}
+
+	available := contextWindow - contextWindow/4
+	mandatory := EstimateMessageTokens(b.Instructions) + EstimateMessageTokens([]OllamaChatMessage{b.Question})
+	if len(b.Context) > 0 {
+		mandatory += EstimateTokens(b.ContextHeader) + messageOverheadTokens
+	}
+	if mandatory > available {
+		return nil, report, fmt.Errorf("%w: instructions and question need ~%d tokens, %d available", ErrPromptTooLarge, mandatory, available)
+	}
+	remaining := available - mandatory
+
+	historyBudget := remaining
+	if len(b.Context) > 0 {
+		historyBudget = remaining / 4
+	}
+	keptHistory := 0
+	historyTokens := 0
+	for i := len(b.History) - 1; i >= 0; i-- {
+		tokens := EstimateMessageTokens(b.History[i : i+1])
+		if historyTokens+tokens > historyBudget {
+			break
+		}
+		historyTokens += tokens
+		keptHistory++
+	}
+	history := b.History[len(b.History)-keptHistory:]
+	report.DroppedHistoryMessages = len(b.History) - keptHistory
+	remaining -= historyTokens
+
+	// Keep the best-scoring chunks, then restore retrieval order
+	ranked := make([]int, len(b.Context))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(x, y int) bool {
+		return b.Context[ranked[x]].Score > b.Context[ranked[y]].Score
+	})
+	keep := make([]bool, len(b.Context))
+	for _, i := range ranked {
+		tokens := EstimateTokens(b.Context[i].Content) + 1
+		if tokens > remaining {
+			report.DroppedContext = append(report.DroppedContext, b.Context[i].Label)
+			continue
+		}
+		remaining -= tokens
+		keep[i] = true
+	}
+
+	messages := append([]OllamaChatMessage{}, b.Instructions...)
+	messages = append(messages, history...)
+	if len(b.Context) > 0 {
+		var data strings.Builder
+		data.WriteString(b.ContextHeader)
+		for i, chunk := range b.Context {
+			if keep[i] {
+				data.WriteString(chunk.Content)
+				data.WriteString("\n")
+			}
+		}
+		messages = append(messages, OllamaChatMessage{Role: "user", Content: data.String()})
+	}
+	messages = append(messages, b.Question)
+
+	report.EstimatedTokens = EstimateMessageTokens(messages)
+	return messages, report, nil
+}
+
+// buildPrompt fits the prompt into the model's context window, or num_ctx when
+// the options set it, and records anything that had to be left out
+func buildPrompt(ctx context.Context, stage Stage, model string, options models.GenerationOptions, builder PromptBuilder) ([]OllamaChatMessage, error) {
+	var contextWindow int
+	if options.NumCtx != nil && *options.NumCtx > 0 {
+		contextWindow = *options.NumCtx
+	} else {
+		contextWindow = ModelContextWindow(ctx, model)
+	}
+	messages, report, err := builder.Build(contextWindow)
+	if err != nil {
+		return nil, fmt.Errorf("%s prompt: %w", stage, err)
+	}
+
+	if len(report.DroppedContext) > 0 || report.DroppedHistoryMessages > 0 {
+		report.Stage = string(stage)
+		slog.InfoContext(ctx, "Trimmed prompt to fit the context window",
+			"stage", stage,
+			"model", model,
+			"estimated_tokens", report.EstimatedTokens,
+			"context_window", report.ContextWindow,
+			"dropped_context", len(report.DroppedContext),
+			"dropped_history_messages", report.DroppedHistoryMessages,
+		)
+		collectorFrom(ctx).addTrimmed(report)
+	}
+	return messages, nil
+}
+
+// renderPrompt renders a prompt template into a system and a user message and
+// records the template version used
+func renderPrompt(ctx context.Context, name string, data prompts.Data) (OllamaChatMessage, OllamaChatMessage, error) {
+	rendered, err := prompts.Render(name, data)
+	if err != nil {
+		return OllamaChatMessage{}, OllamaChatMessage{}, err
+	}
+	collectorFrom(ctx).addPromptVersion(rendered.Name, rendered.Version)
+	return OllamaChatMessage{Role: "system", Content: rendered.System},
+		OllamaChatMessage{Role: "user", Content: rendered.User},
+		nil
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPromptBuilderBuild(t *testing.T) {
+	// Short messages are estimated at one token plus the message overhead
+	message := func(role, content string) OllamaChatMessage {
+		return OllamaChatMessage{Role: role, Content: content}
+	}
+	history := []OllamaChatMessage{
+		message("user", "h1"), message("assistant", "h2"), message("user", "h3"),
+		message("assistant", "h4"), message("user", "h5"),
+	}
+	// Chunks of 80 characters are estimated at 20 tokens plus a newline
+	chunk := func(label string, score float64) ContextChunk {
+		return ContextChunk{Label: label, Content: strings.Repeat(label, 80), Score: score}
+	}
+
+	tests := []struct {
+		name          string
+		builder       PromptBuilder
+		contextWindow int
+		wantErr       error
+		// Contents of the messages, in order
+		wantContents       []string
+		wantDroppedHistory int
+		wantDroppedContext []string
+	}{
+		{
+			name: "everything fits",
+			builder: PromptBuilder{
+				Instructions: []OllamaChatMessage{message("system", "s")},
+				History:      history[:2],
+				Question:     message("user", "q"),
+			},
+			contextWindow: 1000,
+			wantContents:  []string{"s", "h1", "h2", "q"},
+		},
+		{
+			// 30 tokens available, 10 for instructions and question, 20 for history
+			name: "history is trimmed oldest first",
+			builder: PromptBuilder{
+				Instructions: []OllamaChatMessage{message("system", "s")},
+				History:      history,
+				Question:     message("user", "q"),
+			},
+			contextWindow:      40,
+			wantContents:       []string{"s", "h2", "h3", "h4", "h5", "q"},
+			wantDroppedHistory: 1,
+		},
+		{
+			// 60 tokens available, 14 mandatory; history gets a quarter of the
+			// other 46, context the rest
+			name: "history gets a quarter of the budget next to context",
+			builder: PromptBuilder{
+				Instructions: []OllamaChatMessage{message("system", "s")},
+				History:      history,
+				Context:      []ContextChunk{chunk("a", 1)},
+				Question:     message("user", "q"),
+			},
+			contextWindow:      80,
+			wantContents:       []string{"s", "h4", "h5", strings.Repeat("a", 80) + "\n", "q"},
+			wantDroppedHistory: 3,
+		},
+		{
+			// 46 tokens left for context fit two of the three chunks
+			name: "lowest scoring context is dropped and retrieval order kept",
+			builder: PromptBuilder{
+				Instructions: []OllamaChatMessage{message("system", "s")},
+				Context:      []ContextChunk{chunk("a", 0.9), chunk("b", 0.1), chunk("c", 0.5)},
+				Question:     message("user", "q"),
+			},
+			contextWindow:      80,
+			wantContents:       []string{"s", strings.Repeat("a", 80) + "\n" + strings.Repeat("c", 80) + "\n", "q"},
+			wantDroppedContext: []string{"b"},
+		},
+		{
+			name: "context that fits nothing is all dropped",
+			builder: PromptBuilder{
+				Instructions:  []OllamaChatMessage{message("system", "s")},
+				ContextHeader: "Data:\n",
+				Context:       []ContextChunk{chunk("a", 0.9), chunk("b", 0.1)},
+				Question:      message("user", "q"),
+			},
+			contextWindow:      24,
+			wantContents:       []string{"s", "Data:\n", "q"},
+			wantDroppedContext: []string{"a", "b"},
+		},
+		{
+			name: "instructions and question over budget",
+			builder: PromptBuilder{
+				Instructions: []OllamaChatMessage{message("system", strings.Repeat("s", 40))},
+				History:      history,
+				Question:     message("user", "q"),
+			},
+			contextWindow: 16,
+			wantErr:       ErrPromptTooLarge,
+		},
+		{
+			name: "context header over budget",
+			builder: PromptBuilder{
+				Instructions:  []OllamaChatMessage{message("system", "s")},
+				ContextHeader: strings.Repeat("d", 40),
+				Context:       []ContextChunk{chunk("a", 1)},
+				Question:      message("user", "q"),
+			},
+			contextWindow: 24,
+			wantErr:       ErrPromptTooLarge,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			messages, report, err := test.builder.Build(test.contextWindow)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v, want %v", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			contents := make([]string, len(messages))
+			for i, message := range messages {
+				contents[i] = message.Content
+			}
+			if !slices.Equal(contents, test.wantContents) {
+				t.Errorf("got messages %q, want %q", contents, test.wantContents)
+			}
+			if report.DroppedHistoryMessages != test.wantDroppedHistory {
+				t.Errorf("dropped %d history messages, want %d", report.DroppedHistoryMessages, test.wantDroppedHistory)
+			}
+			if !slices.Equal(report.DroppedContext, test.wantDroppedContext) {
+				t.Errorf("dropped context %v, want %v", report.DroppedContext, test.wantDroppedContext)
+			}
+			if budget := test.contextWindow - test.contextWindow/4; report.EstimatedTokens > budget {
+				t.Errorf("estimated %d tokens, over the budget of %d", report.EstimatedTokens, budget)
+			}
+		})
+	}
+}
@@ -1,81 +1,261 @@
 package llm
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"orchestrator/internal/models"
-	"sync"
+	"orchestrator/internal/prompts"
+	"orchestrator/internal/tracing"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func ProcessLLMRAGQuerySingleNode(request models.LLMRAGQueryRequest) (string, error) {
-    data, err := QueryUserRequestForSimilarDocuments(request)
-    if err != nil {
-        return "", err
-    }
+var ErrAllSubQuestionsFailed = errors.New("no sub-question could be answered")
+
+func ProcessLLMRAGQuerySingleNode(ctx context.Context, request models.LLMRAGQueryRequest) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "rag.single", attribute.String("llm.model", request.Model))
+	defer func() { tracing.End(span, err) }()
 
-	return QueryOllama(request.Model, []OllamaChatMessage{
-		{Role: "user", Content: string(GameFIGeniusInstruction)},
-		{Role: "user", Content: "DATA:\n" + data},
-		{Role: "user", Content: "QUERY:\n" + request.Input},
+	data, _, err := QueryUserRequestForSimilarDocuments(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := WithStageTimeout(ctx, StageStep)
+	defer cancel()
+	system, question, err := renderPrompt(ctx, prompts.Step, prompts.Data{Question: request.Input})
+	if err != nil {
+		return "", err
+	}
+	options := ResolveOptions(StageStep, stageOverride(request, StageStep), request.Options)
+	messages, err := buildPrompt(ctx, StageStep, request.Model, options, PromptBuilder{
+		Instructions:  []OllamaChatMessage{system},
+		ContextHeader: "DATA:\n",
+		Context:       data,
+		Question:      question,
 	})
+	if err != nil {
+		return "", err
+	}
+	return QueryOllama(ctx, request.Model, messages, options)
 }
 
-func ProcessLLMRAGQueryMultiNode(request models.LLMRAGQueryRequest) (string, error) {
-	fmt.Println("RAGGING!\n")
-	// Generate sub-questions
-	fmt.Println("making sub questions\n")
-	decomposed_query_request, err := QueryOllama(request.Model, []OllamaChatMessage{
-		{Role: "user", Content: string(SubquestionInstruction)},
-		{Role: "user", Content: request.Input},
+func ProcessLLMRAGQueryMultiNode(ctx context.Context, request models.LLMRAGQueryRequest) (models.LLMRAGQueryResponse, error) {
+	minSteps, maxSteps, err := SubQuestionBounds(request)
+	if err != nil {
+		return models.LLMRAGQueryResponse{}, err
+	}
+	dataSources, err := AllowedDataSources(request.DataSources)
+	if err != nil {
+		return models.LLMRAGQueryResponse{}, err
+	}
+	if err := ValidateStageOptions(request.StageOptions); err != nil {
+		return models.LLMRAGQueryResponse{}, err
+	}
+
+	trace := &models.RAGTrace{SubResults: []models.SubResult{}}
+	response := models.LLMRAGQueryResponse{}
+	if request.Debug {
+		response.Trace = trace
+	}
+
+	plan, err := GenerateQueryPlan(ctx, request.Model, request.Input, minSteps, maxSteps, dataSources, ResolveOptions(StagePlan, stageOverride(request, StagePlan)))
+	if err != nil {
+		// Nobody is waiting for a fallback answer
+		if ctx.Err() != nil {
+			return response, ctx.Err()
+		}
+		// Answering the query directly beats failing the whole request
+		slog.WarnContext(ctx, "Falling back to single-node RAG", "error", err)
+		trace.FallbackReason, trace.FallbackErr = err.Error(), err
+		response.Response, err = ProcessLLMRAGQuerySingleNode(ctx, request)
+		return response, err
+	}
+
+	trace.SubResults = ExecuteQueryPlan(ctx, request, plan)
+
+	if !slices.ContainsFunc(trace.SubResults, func(r models.SubResult) bool { return r.Success }) {
+		// Keep the first step error, so that timeouts and upstream failures are answered as such
+		if i := slices.IndexFunc(trace.SubResults, func(r models.SubResult) bool { return r.Err != nil }); i >= 0 {
+			return response, fmt.Errorf("%w: %w", ErrAllSubQuestionsFailed, trace.SubResults[i].Err)
+		}
+		return response, fmt.Errorf("%w: %s", ErrAllSubQuestionsFailed, trace.SubResults[0].Error)
+	}
+
+	response.Response, err = synthesize(ctx, request, trace.SubResults)
+	return response, err
+}
+
+// synthesize combines the answers to the sub-questions into the final answer
+func synthesize(ctx context.Context, request models.LLMRAGQueryRequest, subResults []models.SubResult) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "rag.synthesis",
+		attribute.String("llm.model", request.Model),
+		attribute.Int("rag.sub_results", len(subResults)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	synthesisCtx, cancel := WithStageTimeout(ctx, StageSynthesis)
+	defer cancel()
+	system, question, err := renderPrompt(ctx, prompts.Synthesis, prompts.Data{
+		Question:   request.Input,
+		Unanswered: FormatUnansweredSubQuestions(subResults),
 	})
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("sub questions generated\n")
-	fmt.Println(decomposed_query_request)
-	fmt.Println("parsing sub questions\n")
-	decomposed_query, err := ParseSubQuestions(decomposed_query_request)
+	options := ResolveOptions(StageSynthesis, stageOverride(request, StageSynthesis), request.Options)
+	messages, err := buildPrompt(synthesisCtx, StageSynthesis, request.Model, options, PromptBuilder{
+		Instructions:  []OllamaChatMessage{system},
+		ContextHeader: "Sub-questions and Answers:\n",
+		Context:       SubQuestionAnswerChunks(subResults),
+		Question:      question,
+	})
 	if err != nil {
 		return "", err
 	}
-	fmt.Println(decomposed_query)
-	fmt.Println("threading\n")
-	// Process sub-questions concurrently
-	var subQuestionAnswers []string
-	answersChan := make(chan string, len(decomposed_query))
-	var wg sync.WaitGroup
-
-	for _, question := range decomposed_query {
-		wg.Add(1)
-		go func(q string) {
-			defer wg.Done()
-			fmt.Println("doing sub question!\n")
-			answer, err := ProcessLLMRAGQuerySingleNode(
-				models.LLMRAGQueryRequest{Model: request.Model,
-					Input:          q,
-					SearchLimit:    request.SearchLimit,
-					DataSources:    request.DataSources,
-					ConversationID: request.ConversationID})
+	return QueryOllama(synthesisCtx, request.Model, messages, options)
+}
+
+// ExecuteQueryPlan runs every step as soon as its dependencies have finished,
+// so independent steps run in parallel. The plan must already be validated.
+// Results are returned in plan order.
+func ExecuteQueryPlan(ctx context.Context, request models.LLMRAGQueryRequest, plan QueryPlan) []models.SubResult {
+	index := make(map[string]int, len(plan.Steps))
+	done := make([]chan struct{}, len(plan.Steps))
+	results := make([]models.SubResult, len(plan.Steps))
+	for i, step := range plan.Steps {
+		index[step.ID] = i
+		done[i] = make(chan struct{})
+		results[i] = models.SubResult{
+			StepID:     step.ID,
+			Question:   step.Question,
+			DataSource: step.DataSource,
+			DependsOn:  step.DependsOn,
+		}
+	}
+
+	for i, step := range plan.Steps {
+		go func(i int, step PlanStep) {
+			defer close(done[i])
+			ctx, span := tracing.Start(ctx, "rag.step",
+				attribute.String("rag.step_id", step.ID),
+				attribute.String("rag.data_source", step.DataSource),
+				attribute.StringSlice("rag.depends_on", step.DependsOn),
+			)
+			defer func() {
+				err := results[i].Err
+				if err == nil && !results[i].Success {
+					err = errors.New(results[i].Error)
+				}
+				tracing.End(span, err)
+			}()
+
+			var earlier []string
+			for _, dep := range step.DependsOn {
+				<-done[index[dep]]
+				depResult := results[index[dep]]
+				if !depResult.Success {
+					results[i].Error = fmt.Sprintf("dependency %q failed", dep)
+					return
+				}
+				earlier = append(earlier, fmt.Sprintf("[%s] %s\n%s", dep, depResult.Question, depResult.Answer))
+			}
+
+			answer, sources, err := answerPlanStep(ctx, request, step, earlier)
+			results[i].Sources = sources
 			if err != nil {
-				answersChan <- fmt.Sprintf("Error answering sub-question: %v", err)
-			} else {
-				answersChan <- fmt.Sprintf("Sub-question: %s\nAnswer: %s", q, answer)
+				results[i].Error, results[i].Err = err.Error(), err
+				return
 			}
-		}(question)
-		fmt.Println("moving to next sub question!\n")
+			if answer == "" {
+				results[i].Error = "model returned an empty answer"
+				return
+			}
+			results[i].Answer = answer
+			results[i].Success = true
+		}(i, step)
+	}
+
+	for _, ch := range done {
+		<-ch
 	}
+	return results
+}
 
-	wg.Wait()
-	close(answersChan)
+// answerPlanStep retrieves data for a step from its data source and answers it,
+// giving the model the results of the steps it depends on
+func answerPlanStep(ctx context.Context, request models.LLMRAGQueryRequest, step PlanStep, earlier []string) (string, []models.Source, error) {
+	earlierResults := strings.Join(earlier, "\n\n")
+	searchInput := step.Question
+	if earlierResults != "" {
+		searchInput += "\n\nResults of earlier steps:\n" + earlierResults
+	}
+	ctx, cancel := WithStageTimeout(ctx, StageStep)
+	defer cancel()
 
-	for answer := range answersChan {
-		fmt.Println("collating result!\n")
-		subQuestionAnswers = append(subQuestionAnswers, answer)
+	stepRequest := models.LLMRAGQueryRequest{
+		Model:          request.Model,
+		EmbeddingModel: request.EmbeddingModel,
+		Input:          searchInput,
+		SearchLimit:    request.SearchLimit,
+		ConversationID: request.ConversationID,
 	}
 
-	fmt.Println("returning result!\n")
-	return QueryOllama(request.Model, []OllamaChatMessage{
-		{Role: "user", Content: string(SynthesizeInstruction)},
-		{Role: "user", Content: "Original Query: " + request.Input},
-		{Role: "user", Content: "Sub-questions and Answers:\n" + FormatSubQuestionAnswers(subQuestionAnswers)},
+	var data []ContextChunk
+	var sources []models.Source
+	var err error
+	switch step.DataSource {
+	case DataSourceSQL:
+		var query string
+		var rows []map[string]interface{}
+		query, rows, err = GenerateAndExecuteSQL(ctx, request.Model, searchInput, ResolveOptions(StageSQL, stageOverride(request, StageSQL)))
+		if query != "" {
+			sources = []models.Source{{Type: "sql", Reference: query}}
+		}
+		if err == nil {
+			data, err = sqlResultChunks(rows)
+		}
+	case DataSourceDocuments:
+		data, sources, err = QueryUserRequestForSimilarDocuments(ctx, stepRequest)
+	case DataSourceRows:
+		data, sources, err = QueryUserRequestForSimilarRows(ctx, stepRequest)
+	default:
+		err = fmt.Errorf("unknown data source %q", step.DataSource)
+	}
+	if err != nil {
+		return "", sources, fmt.Errorf("error retrieving %s data: %w", step.DataSource, err)
+	}
+
+	system, question, err := renderPrompt(ctx, prompts.Step, prompts.Data{
+		Question:       step.Question,
+		EarlierResults: earlierResults,
 	})
+	if err != nil {
+		return "", sources, err
+	}
+	// The caller's options apply to the sub-answers like they do to the other
+	// stages, except for the format meant for the final answer
+	callerOptions := request.Options
+	if callerOptions != nil && callerOptions.Format != nil {
+		withoutFormat := *callerOptions
+		withoutFormat.Format = nil
+		callerOptions = &withoutFormat
+	}
+	options := ResolveOptions(StageStep, stageOverride(request, StageStep), callerOptions)
+	messages, err := buildPrompt(ctx, StageStep, request.Model, options, PromptBuilder{
+		Instructions:  []OllamaChatMessage{system},
+		ContextHeader: "DATA:\n",
+		Context:       data,
+		Question:      question,
+	})
+	if err != nil {
+		return "", sources, err
+	}
+
+	answer, err := QueryOllama(ctx, request.Model, messages, options)
+	return answer, sources, err
 }
@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+	"orchestrator/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// QueryUserRequestForSimilarRows returns the nearest rows of every embedded
+// table, scored by cosine similarity to the request input
+func QueryUserRequestForSimilarRows(ctx context.Context, request models.LLMRAGQueryRequest) (_ []ContextChunk, _ []models.Source, err error) {
+	ctx, span := tracing.Start(ctx, "rag.retrieval",
+		attribute.String("rag.data_source", DataSourceRows),
+		attribute.Int("rag.search_limit", request.SearchLimit),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := WithStageTimeout(ctx, StageRetrieval)
+	defer cancel()
+
+	db := database.Pool()
+
+	query_embedding, err := CreateEmbedding(ctx, request.EmbeddingModel, request.Input)
+	if err != nil {
+		return nil, nil, err
+	}
+	similarRows, err := database.GetAllSimilarRowsFromDB(ctx, db, query_embedding, request.SearchLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chunks []ContextChunk
+	var sources []models.Source
+	for _, tableName := range database.TableNames {
+		rows, ok := similarRows[tableName]
+		if !ok {
+			continue
+		}
+		sources = append(sources, models.Source{Type: "table", Reference: tableName})
+		for i, row := range rows {
+			distance, _ := row["similarity_distance"].(float64)
+			delete(row, "similarity_distance")
+			delete(row, "embedding")
+
+			rowJSON, err := json.Marshal(row)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error marshaling row from %s: %w", tableName, err)
+			}
+			chunks = append(chunks, ContextChunk{
+				Label:   fmt.Sprintf("%s row %d", tableName, i+1),
+				Content: fmt.Sprintf("Table %s: %s", tableName, rowJSON),
+				Score:   1 - distance,
+			})
+		}
+	}
+
+	span.SetAttributes(attribute.Int("rag.results", len(chunks)))
+	return chunks, sources, nil
+}
@@ -1,12 +1,20 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"orchestrator/internal/database"
+	"orchestrator/internal/models"
+	"orchestrator/internal/prompts"
+	"orchestrator/internal/tracing"
 	"regexp"
 	"strings"
+	"unicode"
 
 	_ "github.com/tmc/langchaingo/tools/sqldatabase/postgresql"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func SanitizeAndParseSQLQuery(query string) (string, error) {
@@ -23,48 +31,121 @@ func SanitizeAndParseSQLQuery(query string) (string, error) {
 	regex := regexp.MustCompile(`(?i)(` + pattern + `)`)
 
 	if match := regex.FindString(query); match != "" {
-		return "", fmt.Errorf("disallowed keyword or pattern found: %s", match)
+		return "", fmt.Errorf("%w: disallowed keyword or pattern found: %s", ErrSQLRejected, match)
 	}
 
 	if !regexp.MustCompile(`(?i)^\s*SELECT\b`).MatchString(query) {
-		return "", fmt.Errorf("query must start with SELECT")
+		return "", fmt.Errorf("%w: query must start with SELECT", ErrSQLRejected)
 	}
 
 	if !regexp.MustCompile(`(?i)\bFROM\b`).MatchString(query) {
-		return "", fmt.Errorf("invalid query structure: missing FROM clause")
+		return "", fmt.Errorf("%w: missing FROM clause", ErrSQLRejected)
 	}
 
 	return query, nil
 }
 
-func QueryUserRequestAsSQL(modelName string, input any) (string, error) {
-	db, err := database.CreateDatabaseConnectionFromEnv()
+func QueryUserRequestAsSQL(ctx context.Context, modelName string, input any, options models.GenerationOptions) (string, error) {
+	_, result, err := GenerateAndExecuteSQL(ctx, modelName, input, options)
 	if err != nil {
-		return "", fmt.Errorf("error creating database connection: %w", err)
+		return "", err
 	}
-	defer db.Close()
-	tableSchema, err := database.GetTableSchemaAsString()
+	return fmt.Sprintf("%v", result), nil
+}
+
+// GenerateAndExecuteSQL returns the executed query along with its result rows
+func GenerateAndExecuteSQL(ctx context.Context, modelName string, input any, options models.GenerationOptions) (_ string, _ []map[string]interface{}, err error) {
+	ctx, span := tracing.Start(ctx, "rag.sql", attribute.String("llm.model", modelName))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := WithStageTimeout(ctx, StageSQL)
+	defer cancel()
+
+	db := database.Pool()
+	tableSchema, err := database.GetTableSchemaAsString(ctx, db)
 	if err != nil {
-		return "", fmt.Errorf("error getting table schema: %w", err)
+		return "", nil, fmt.Errorf("error getting table schema: %w", err)
 	}
 
-	query, err := QueryOllama(modelName, []OllamaChatMessage{
-		{Role: "user", Content: string(SQLInstruction)},
-		{Role: "user", Content: tableSchema},
-		{Role: "user", Content: "QUERY:\n" + fmt.Sprintf("%v", input)}})
+	question := fmt.Sprintf("%v", input)
+	system, user, err := renderPrompt(ctx, prompts.SQL, prompts.Data{Question: question})
+	if err != nil {
+		return "", nil, err
+	}
+	messages, err := buildPrompt(ctx, StageSQL, modelName, options, PromptBuilder{
+		Instructions:  []OllamaChatMessage{system},
+		ContextHeader: "DATABASE SCHEMA:\n",
+		Context:       schemaChunks(tableSchema, question),
+		Question:      user,
+	})
+	if err != nil {
+		return "", nil, err
+	}
 
+	query, err := QueryOllama(ctx, modelName, messages, options)
 	if err != nil {
-		return "", fmt.Errorf("error querying Ollama: %w", err)
+		return "", nil, fmt.Errorf("error querying Ollama: %w", err)
 	}
 
 	query, err = SanitizeAndParseSQLQuery(query)
 	if err != nil {
-		return "", fmt.Errorf("error sanitizing and parsing SQL query: %w", err)
+		return "", nil, err
 	}
 
-	result, err := database.ExecuteSQLQuery(db, query)
+	// The checks above only catch obvious mistakes. What the query can read is
+	// limited by the role it runs as
+	result, err := database.ExecuteSQLQuery(ctx, db, query)
+	if errors.Is(err, database.ErrSQLRole) {
+		return query, nil, err
+	}
 	if err != nil {
-		return "", fmt.Errorf("error executing SQL query: %w", err)
+		return query, nil, fmt.Errorf("%w: error executing it: %w", ErrSQLRejected, err)
 	}
-	return fmt.Sprintf("%v", result), nil
+	span.SetAttributes(attribute.Int("db.rows", len(result)))
+	return query, result, nil
+}
+
+// schemaChunks splits the schema into one chunk per table, scored by how many
+// of the table and column names appear in the question, so the least relevant
+// tables are dropped first when the schema does not fit
+func schemaChunks(tableSchema string, question string) []ContextChunk {
+	questionWords := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), isIdentifierSeparator) {
+		questionWords[word] = true
+	}
+
+	var chunks []ContextChunk
+	for _, line := range strings.Split(strings.TrimSpace(tableSchema), "\n") {
+		tableName, _, _ := strings.Cut(line, ":")
+		score := 0.0
+		for _, word := range strings.FieldsFunc(strings.ToLower(line), isIdentifierSeparator) {
+			if questionWords[word] {
+				score++
+			}
+		}
+		chunks = append(chunks, ContextChunk{Label: "table " + tableName, Content: line, Score: score})
+	}
+	return chunks
+}
+
+func isIdentifierSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// sqlResultChunks turns result rows into context, keeping the query's own
+// ordering as the relevance order
+func sqlResultChunks(rows []map[string]interface{}) ([]ContextChunk, error) {
+	chunks := make([]ContextChunk, 0, len(rows))
+	for i, row := range rows {
+		rowJSON, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling SQL result row: %w", err)
+		}
+		chunks = append(chunks, ContextChunk{
+			Label:   fmt.Sprintf("sql row %d", i+1),
+			Content: string(rowJSON),
+			Score:   1 - float64(i)/float64(len(rows)),
+		})
+	}
+	return chunks, nil
 }
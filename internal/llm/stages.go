@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"orchestrator/internal/config"
+	"slices"
+	"time"
+)
+
+// Stage names a step of the LLM pipeline
+type Stage string
+
+const (
+	StageChat      Stage = "chat"
+	StagePlan      Stage = "plan"
+	StageRetrieval Stage = "retrieval"
+	StageSQL       Stage = "sql"
+	StageStep      Stage = "step"
+	StageSynthesis Stage = "synthesis"
+	StageEmbedding Stage = "embedding"
+	StageIngestion Stage = "ingestion"
+)
+
+// DefaultStageTimeouts bound how long each stage may take. They can be
+// overridden per deployment with llm.stage_timeouts in the config or
+// ORCHESTRATOR_TIMEOUT_<STAGE>, e.g. ORCHESTRATOR_TIMEOUT_SYNTHESIS=90s
+var DefaultStageTimeouts = map[Stage]time.Duration{
+	StageChat:      2 * time.Minute,
+	StagePlan:      time.Minute,
+	StageRetrieval: 30 * time.Second,
+	StageSQL:       time.Minute,
+	StageStep:      2 * time.Minute,
+	StageSynthesis: 2 * time.Minute,
+	StageEmbedding: 30 * time.Second,
+	StageIngestion: 30 * time.Minute,
+}
+
+// StageTimeout returns the configured deadline for a stage
+func StageTimeout(stage Stage) time.Duration {
+	if timeout, ok := config.Get().LLM.StageTimeouts[string(stage)]; ok {
+		return time.Duration(timeout)
+	}
+	return DefaultStageTimeouts[stage]
+}
+
+// ValidateConfig rejects stage timeouts and options for stages that do not exist
+func ValidateConfig(settings config.LLM) error {
+	for stage := range settings.StageTimeouts {
+		if _, ok := DefaultStageTimeouts[Stage(stage)]; !ok {
+			return fmt.Errorf("llm.stage_timeouts: unknown stage %q", stage)
+		}
+	}
+	for stage := range settings.StageOptions {
+		if !slices.Contains(ConfigurableStages, Stage(stage)) {
+			return fmt.Errorf("llm.stage_options: unknown stage %q", stage)
+		}
+	}
+	return nil
+}
+
+type stageKey struct{}
+
+// WithStageTimeout derives a context that expires after the stage's deadline.
+// Model calls made with it are accounted to the stage, which is reported as
+// finished once the context is cancelled.
+func WithStageTimeout(ctx context.Context, stage Stage) (context.Context, context.CancelFunc) {
+	finished := reportStage(ctx, stage)
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, stageKey{}, stage), StageTimeout(stage))
+	return ctx, func() {
+		cancel()
+		finished()
+	}
+}
+
+// stageFrom returns the innermost stage the context was derived for
+func stageFrom(ctx context.Context) Stage {
+	stage, _ := ctx.Value(stageKey{}).(Stage)
+	return stage
+}
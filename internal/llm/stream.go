@@ -0,0 +1,74 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type tokenHandlerKey struct{}
+
+// WithTokenHandler returns a context whose chat stage passes each piece of the
+// answer to handle as Ollama streams it, before the full answer is returned
+func WithTokenHandler(ctx context.Context, handle func(token string)) context.Context {
+	return context.WithValue(ctx, tokenHandlerKey{}, handle)
+}
+
+// tokenHandlerFrom returns the handler of the request if ctx is in a stage
+// whose output is the answer itself
+func tokenHandlerFrom(ctx context.Context) func(string) {
+	if stageFrom(ctx) != StageChat {
+		return nil
+	}
+	handle, _ := ctx.Value(tokenHandlerKey{}).(func(string))
+	return handle
+}
+
+// interruptedStream keeps a chat call from being retried once part of its
+// answer reached the caller, since the retry would send it again
+func interruptedStream(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: the answer stream broke off: %v", ErrUpstreamUnavailable, err)
+}
+
+// Statuses of stage events
+const (
+	StageStarted  = "started"
+	StageFinished = "finished"
+)
+
+// StageEvent reports a pipeline stage starting or finishing
+type StageEvent struct {
+	Stage  Stage
+	Status string
+	// Set when the stage has finished
+	Duration time.Duration
+}
+
+type stageHandlerKey struct{}
+
+// WithStageHandler returns a context whose pipeline stages are reported to
+// handle as they start and finish. Stages may run in parallel, so handle must
+// be safe for concurrent use
+func WithStageHandler(ctx context.Context, handle func(StageEvent)) context.Context {
+	return context.WithValue(ctx, stageHandlerKey{}, handle)
+}
+
+// reportStage reports the start of the stage and returns the function that
+// reports its end, which may be called more than once
+func reportStage(ctx context.Context, stage Stage) func() {
+	handle, _ := ctx.Value(stageHandlerKey{}).(func(StageEvent))
+	if handle == nil {
+		return func() {}
+	}
+	start := time.Now()
+	handle(StageEvent{Stage: stage, Status: StageStarted})
+	var once sync.Once
+	return func() {
+		once.Do(func() { handle(StageEvent{Stage: stage, Status: StageFinished, Duration: time.Since(start)}) })
+	}
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"orchestrator/internal/config"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Ollama runs models with this context window unless num_ctx says otherwise
+const DefaultContextWindow = config.DefaultContextWindow
+
+// Tokens added by the chat template around every message
+const messageOverheadTokens = 4
+
+var contextWindows sync.Map
+
+// EstimateTokens approximates the token count of text. Roughly four characters
+// per token holds for English prose with llama-style tokenizers; word count is
+// used as a floor for text made of many short words.
+func EstimateTokens(text string) int {
+	byChars := (utf8.RuneCountInString(text) + 3) / 4
+	byWords := len(strings.Fields(text)) * 4 / 3
+	return max(byChars, byWords)
+}
+
+// EstimateMessageTokens approximates the prompt size of a list of chat messages
+func EstimateMessageTokens(messages []OllamaChatMessage) int {
+	total := 0
+	for _, message := range messages {
+		total += EstimateTokens(message.Content) + messageOverheadTokens
+	}
+	return total
+}
+
+// ModelContextWindow returns the number of tokens a model will accept. Values
+// from llm.context_windows in the config or ORCHESTRATOR_CONTEXT_WINDOWS
+// ("llama3:8b=8192,mistral=32768") take precedence, then the num_ctx the model
+// is configured with in Ollama.
+func ModelContextWindow(ctx context.Context, model string) int {
+	if window, ok := config.Get().LLM.ContextWindows[model]; ok {
+		return window
+	}
+	if window, ok := contextWindows.Load(model); ok {
+		return window.(int)
+	}
+
+	window, err := showModelContextWindow(ctx, model)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not determine context window, using the default", "model", model, "context_window", DefaultContextWindow, "error", err)
+		return DefaultContextWindow
+	}
+	contextWindows.Store(model, window)
+	return window
+}
+
+// showModelContextWindow reads num_ctx from Ollama's /api/show. The model's
+// trained context length is only used as an upper bound, since Ollama
+// truncates prompts to num_ctx regardless of what the model supports.
+func showModelContextWindow(ctx context.Context, model string) (int, error) {
+	jsonQuery, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := postOllama(ctx, "/api/show", jsonQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var show OllamaShowResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, err
+	}
+
+	window := DefaultContextWindow
+	for _, line := range strings.Split(show.Parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if numCtx, err := strconv.Atoi(fields[1]); err == nil && numCtx > 0 {
+				window = numCtx
+			}
+		}
+	}
+	for key, value := range show.ModelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		if trained, ok := value.(float64); ok && trained > 0 {
+			window = min(window, int(trained))
+		}
+	}
+	return window, nil
+}
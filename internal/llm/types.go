@@ -1,9 +1,27 @@
 package llm
 
+import (
+	"encoding/json"
+	"orchestrator/internal/models"
+	"time"
+)
+
 // Ollama HTTP request format
 type OllamaRequest struct {
 	Model    string              `json:"model"`
 	Messages []OllamaChatMessage `json:"messages"`
+	// Either "json" or a JSON schema the response must conform to
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *OllamaOptions  `json:"options,omitempty"`
+}
+
+// Ollama model parameters; unset fields use the model's defaults
+type OllamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumCtx      *int     `json:"num_ctx,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 // Ollama HTTP response format
@@ -14,6 +32,7 @@ type OllamaResponse struct {
 		Role    string `json:"role"`
 		Content string `json:"content"`
 	} `json:"message"`
+	Error              string `json:"error,omitempty"`
 	DoneReason         string `json:"done_reason"`
 	Done               bool   `json:"done"`
 	TotalDuration      int64  `json:"total_duration"`
@@ -24,11 +43,48 @@ type OllamaResponse struct {
 	EvalDuration       int64  `json:"eval_duration"`
 }
 
+// Usage converts the metrics of the final response line; durations are reported in nanoseconds
+func (r OllamaResponse) Usage() models.Usage {
+	return models.Usage{
+		Calls:                1,
+		PromptTokens:         r.PromptEvalCount,
+		CompletionTokens:     r.EvalCount,
+		TotalTokens:          r.PromptEvalCount + r.EvalCount,
+		TotalDurationMs:      time.Duration(r.TotalDuration).Milliseconds(),
+		LoadDurationMs:       time.Duration(r.LoadDuration).Milliseconds(),
+		PromptEvalDurationMs: time.Duration(r.PromptEvalDuration).Milliseconds(),
+		EvalDurationMs:       time.Duration(r.EvalDuration).Milliseconds(),
+	}
+}
+
 // Ollama Default Message Format
 type OllamaChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
-type Instruction string
 type Model string
+
+// Ollama /api/embeddings request format
+type OllamaEmbeddingRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+// Ollama /api/embeddings response format
+type OllamaEmbeddingResponse struct {
+	Embedding []float32 `json:"embedding"`
+}
+
+// Ollama /api/tags response format, limited to the fields we use
+type OllamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// Ollama /api/show response format, limited to the fields we use
+type OllamaShowResponse struct {
+	Parameters string         `json:"parameters"`
+	ModelInfo  map[string]any `json:"model_info"`
+}
@@ -0,0 +1,17 @@
+package llm
+
+import (
+	"orchestrator/internal/metrics"
+	"orchestrator/internal/models"
+)
+
+// RecordUsage adds the tokens of a request made by user to the per-user usage metrics
+func RecordUsage(user string, report *models.UsageReport) {
+	if report == nil {
+		return
+	}
+	for _, stage := range report.Stages {
+		metrics.UserTokens.WithLabelValues(user, stage.Model, stage.Stage, "prompt").Add(float64(stage.PromptTokens))
+		metrics.UserTokens.WithLabelValues(user, stage.Model, stage.Stage, "completion").Add(float64(stage.CompletionTokens))
+	}
+}
@@ -2,56 +2,38 @@ package llm
 
 import (
 	"fmt"
+	"orchestrator/internal/models"
 	"strings"
 )
 
-func FormatSubQuestionAnswers(subQuestionAnswers []string) string {
-	var result strings.Builder
-
-	for i, entry := range subQuestionAnswers {
-		// Add a numbered header for each sub-question and answer pair
-		result.WriteString(fmt.Sprintf("%d. %s\n", i+1, entry))
-
-		// Add a separator between entries, except for the last one
-		if i < len(subQuestionAnswers)-1 {
-			result.WriteString("\n---\n\n")
+// SubQuestionAnswerChunks numbers answered sub-results in plan order, so the
+// synthesizer can refer to them. Later sub-questions are dropped first when the
+// answers do not fit the context window.
+func SubQuestionAnswerChunks(subResults []models.SubResult) []ContextChunk {
+	var chunks []ContextChunk
+	for i, subResult := range subResults {
+		if !subResult.Success {
+			continue
 		}
+		chunks = append(chunks, ContextChunk{
+			Label:   fmt.Sprintf("sub-question %d", i+1),
+			Content: fmt.Sprintf("%d. Sub-question: %s\nAnswer: %s\n\n---\n", i+1, subResult.Question, subResult.Answer),
+			Score:   1 - float64(i)/float64(len(subResults)),
+		})
 	}
-
-	return result.String()
+	return chunks
 }
 
-func ParseSubQuestions(response string) ([]string, error) {
-	// Find the opening and closing brackets
-	start := strings.Index(response, "[")
-	end := strings.LastIndex(response, "]")
-
-	if start == -1 || end == -1 || start >= end {
-		return []string{}, nil
-	}
-
-	// Extract the content between the brackets
-	content := response[start+1 : end]
-
-	// Split the content by commas
-	questions := strings.Split(content, ",")
-
-	// Trim whitespace and remove quotes from each question
-	for i, q := range questions {
-		q = strings.TrimSpace(q)
-		q = strings.Trim(q, "\"")
-		questions[i] = q
-	}
-
-	// Remove any empty questions
-	var nonEmptyQuestions []string
-	for _, q := range questions {
-		if q != "" {
-			nonEmptyQuestions = append(nonEmptyQuestions, q)
+// FormatUnansweredSubQuestions lists failed sub-results separately so the
+// synthesizer never mistakes an error for an answer
+func FormatUnansweredSubQuestions(subResults []models.SubResult) string {
+	var unanswered strings.Builder
+	for i, subResult := range subResults {
+		if !subResult.Success {
+			unanswered.WriteString(fmt.Sprintf("%d. %s\n", i+1, subResult.Question))
 		}
 	}
-
-	return nonEmptyQuestions, nil
+	return unanswered.String()
 }
 
 // Helper function to truncate a string
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "orchestrator"
+
+// LLM calls take seconds to minutes, far longer than the default buckets allow for
+var llmBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   llmBuckets,
+	}, []string{"route", "method"})
+
+	GRPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	GRPCRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "gRPC call latency by method.",
+		Buckets:   llmBuckets,
+	}, []string{"method"})
+
+	LLMCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_calls_total",
+		Help:      "Ollama chat calls by model, pipeline stage and outcome (success or error).",
+	}, []string{"model", "stage", "outcome"})
+
+	LLMCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_call_duration_seconds",
+		Help:      "Ollama chat call latency, including retries, by model and pipeline stage.",
+		Buckets:   llmBuckets,
+	}, []string{"model", "stage"})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens processed by model, pipeline stage and type (prompt or completion).",
+	}, []string{"model", "stage", "type"})
+
+	UserTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "user_tokens_total",
+		Help:      "Tokens used by each API user, by model, pipeline stage and type (prompt or completion).",
+	}, []string{"user", "model", "stage", "type"})
+
+	Embeddings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embeddings_total",
+		Help:      "Embeddings requested from Ollama by model and outcome (success or error).",
+	}, []string{"model", "outcome"})
+
+	EmbeddingInputBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embedding_input_bytes_total",
+		Help:      "Bytes of text embedded successfully by model.",
+	}, []string{"model"})
+
+	EmbeddingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "embedding_duration_seconds",
+		Help:      "Embedding call latency, including retries, by model.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"model"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by table and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"table", "operation"})
+
+	DBQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_query_errors_total",
+		Help:      "Failed database queries by table and operation.",
+	}, []string{"table", "operation"})
+
+	JobsInProgress = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "jobs_in_progress",
+		Help:      "Background ingestion jobs currently running by job type.",
+	}, []string{"job"})
+
+	Jobs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_total",
+		Help:      "Finished background ingestion jobs by job type and outcome (success or error).",
+	}, []string{"job", "outcome"})
+
+	IPFSFetchBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ipfs_fetch_bytes",
+		Help:      "Size of files fetched from IPFS.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	IPFSFetchErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ipfs_fetch_errors_total",
+		Help:      "IPFS fetches that failed after retries.",
+	})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests refused with 429 by route and reason (rate or concurrency).",
+	}, []string{"route", "reason"})
+)
+
+// Outcome returns the outcome label for an error
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -4,38 +4,120 @@ import "encoding/json"
 
 // Document Embeddings are sent to vector database
 type DocumentEmbeddingsRequest struct {
-	CID            string `json:"cid"`
+	CID            string `json:"cid" binding:"required"`
 	CollectionSlug string `json:"collection_slug"`
-	Model          string `json:"model,omitempty" default:"nomic-embed-text"`
+	// Defaults to defaults.embedding_model
+	Model string `json:"model,omitempty"`
 }
 
 // Async requests to vectorize rows as data trickles in
 // Primary keys ordered by database order
 type RowEmbeddingsRequest struct {
-	RowPrimaryKey json.RawMessage `json:"row_primary_key"`
-	Model         string          `json:"model,omitempty" default:"nomic-embed-text"`
-	Table         string          `json:"table"`
+	RowPrimaryKey json.RawMessage `json:"row_primary_key" binding:"required"`
+	// Defaults to defaults.embedding_model
+	Model string `json:"model,omitempty"`
+	Table string `json:"table" binding:"required"`
 }
 
 // LLMSimpleQueryRequest represents a simple LLM query without RAG
 type LLMSimpleQueryRequest struct {
-	Input          string `json:"input"`
-	Model          string `json:"model,omitempty" default:"default-model"`
-	ConversationID int64  `json:"conversation_id,omitempty"`
+	Input string `json:"input" binding:"required"`
+	// Defaults to defaults.chat_model
+	Model          string             `json:"model,omitempty"`
+	ConversationID int64              `json:"conversation_id,omitempty"`
+	Options        *GenerationOptions `json:"options,omitempty"`
 }
 
 // LLMRAGQueryRequest represents an LLM query with RAG
 type LLMRAGQueryRequest struct {
-	Input          string   `json:"input"`
-	Model          string   `json:"model,omitempty" default:"default-model"`
-	SearchLimit    int      `json:"search_limit,omitempty" default:"5"`
+	Input string `json:"input" binding:"required"`
+	// Defaults to defaults.chat_model
+	Model string `json:"model,omitempty"`
+	// Embeds the input for retrieval. It must be the model the documents and
+	// rows were embedded with; defaults to defaults.embedding_model
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	// Results per data source; defaults to defaults.search_limit
+	SearchLimit    int      `json:"search_limit,omitempty"`
 	DataSources    []string `json:"data_sources,omitempty"`
 	ConversationID int64    `json:"conversation_id,omitempty"`
+	// Bounds on the number of sub-questions generated by multi-node RAG
+	MinSubQuestions int `json:"min_sub_questions,omitempty"`
+	MaxSubQuestions int `json:"max_sub_questions,omitempty"`
+	// Include the full multi-node trace in the response
+	Debug bool `json:"debug,omitempty"`
+	// Options for the stage producing the final answer, and except for format
+	// for the answers to sub-questions
+	Options *GenerationOptions `json:"options,omitempty"`
+	// Options for individual pipeline stages, keyed by stage name (plan, sql, step, synthesis)
+	StageOptions map[string]GenerationOptions `json:"stage_options,omitempty"`
 }
 
 // LLMSQLQueryRequest represents an LLM query for SQL generation
 type LLMSQLQueryRequest struct {
-	Input          string `json:"input"`
-	Model          string `json:"model,omitempty" default:"default-model"`
-	ConversationID int64  `json:"conversation_id,omitempty"`
+	Input string `json:"input" binding:"required"`
+	// Defaults to defaults.chat_model
+	Model          string             `json:"model,omitempty"`
+	ConversationID int64              `json:"conversation_id,omitempty"`
+	Options        *GenerationOptions `json:"options,omitempty"`
+}
+
+// ChatSocketMessage is sent by clients of the chat WebSocket
+type ChatSocketMessage struct {
+	// "message" asks the model, "cancel" aborts the answer being generated
+	Type string `json:"type"`
+	// The remaining fields are those of a chat query, for messages
+	Input   string             `json:"input,omitempty"`
+	Model   string             `json:"model,omitempty"`
+	Options *GenerationOptions `json:"options,omitempty"`
+}
+
+// GenerationOptions are passed through to Ollama. Unset fields fall back to the
+// stage defaults, and then to the model's own defaults.
+type GenerationOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumCtx      *int     `json:"num_ctx,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	// Either "json" or a JSON schema the response must conform to
+	Format json.RawMessage `json:"format,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Defaults to "default"
+	Tenant string `json:"tenant,omitempty"`
+	// Any of "ingest", "query" and "admin"
+	Roles []string `json:"roles" binding:"required"`
+	// User the key belongs to; the key is then in the user's tenant
+	UserID int64 `json:"user_id,omitempty"`
+}
+
+// UserPreferences replace the configured defaults of a user's requests
+type UserPreferences struct {
+	ChatModel      string `json:"chat_model,omitempty"`
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+	SearchLimit    int    `json:"search_limit,omitempty"`
+}
+
+type CreateUserRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Defaults to "default"
+	Tenant      string          `json:"tenant,omitempty"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Email       string          `json:"email,omitempty"`
+	Preferences UserPreferences `json:"preferences"`
+}
+
+// UpdateUserRequest changes the fields that are present
+type UpdateUserRequest struct {
+	DisplayName *string          `json:"display_name,omitempty"`
+	Email       *string          `json:"email,omitempty"`
+	Preferences *UserPreferences `json:"preferences,omitempty"`
+}
+
+// CorpusShareRequest names a collection of the caller's tenant and the tenant to share it with
+type CorpusShareRequest struct {
+	CollectionSlug string `json:"collection_slug" binding:"required"`
+	SharedWith     string `json:"shared_with" binding:"required"`
 }
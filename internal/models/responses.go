@@ -1,6 +1,184 @@
 package models
 
+import "time"
+
 type LLMQueryResponse struct {
 	Result       string
 	RelevantData string
 }
+
+// LLMResponse is returned by the LLM endpoints
+type LLMResponse struct {
+	Response string `json:"response"`
+	// Prompts that had to be trimmed to fit the model's context window
+	Trimmed        []PromptReport    `json:"trimmed,omitempty"`
+	PromptVersions map[string]string `json:"prompt_versions,omitempty"`
+	Usage          *UsageReport      `json:"usage,omitempty"`
+}
+
+// LLMRAGQueryResponse is returned by the multi-node RAG endpoint; Trace is only set for debug requests
+type LLMRAGQueryResponse struct {
+	LLMResponse
+	Trace *RAGTrace `json:"trace,omitempty"`
+}
+
+// RAGTrace records how a multi-node RAG answer was produced
+type RAGTrace struct {
+	FallbackReason string      `json:"fallback_reason,omitempty"`
+	SubResults     []SubResult `json:"sub_results"`
+	// Why no plan could be made. Only its public message is sent, as FallbackReason
+	FallbackErr error `json:"-"`
+}
+
+// SubResult is the outcome of a single plan step, kept in plan order
+type SubResult struct {
+	StepID     string   `json:"step_id"`
+	Question   string   `json:"question"`
+	DataSource string   `json:"data_source"`
+	DependsOn  []string `json:"depends_on,omitempty"`
+	Success    bool     `json:"success"`
+	Answer     string   `json:"answer,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Sources    []Source `json:"sources,omitempty"`
+	// The error the step failed with. Only its public message is sent, as Error
+	Err error `json:"-"`
+}
+
+// Source identifies data a sub-answer was based on
+type Source struct {
+	// One of "document", "table" or "sql"
+	Type string `json:"type"`
+	// CID for documents, table name for rows, the executed query for sql
+	Reference      string `json:"reference"`
+	CollectionSlug string `json:"collection_slug,omitempty"`
+}
+
+// Usage is the token and time cost of one or more model calls
+type Usage struct {
+	Calls                int   `json:"calls"`
+	PromptTokens         int   `json:"prompt_tokens"`
+	CompletionTokens     int   `json:"completion_tokens"`
+	TotalTokens          int   `json:"total_tokens"`
+	TotalDurationMs      int64 `json:"total_duration_ms"`
+	LoadDurationMs       int64 `json:"load_duration_ms"`
+	PromptEvalDurationMs int64 `json:"prompt_eval_duration_ms"`
+	EvalDurationMs       int64 `json:"eval_duration_ms"`
+}
+
+// Add accumulates other into u
+func (u *Usage) Add(other Usage) {
+	u.Calls += other.Calls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.TotalDurationMs += other.TotalDurationMs
+	u.LoadDurationMs += other.LoadDurationMs
+	u.PromptEvalDurationMs += other.PromptEvalDurationMs
+	u.EvalDurationMs += other.EvalDurationMs
+}
+
+// StageUsage is the usage of one model in one pipeline stage
+type StageUsage struct {
+	Stage string `json:"stage"`
+	Model string `json:"model"`
+	Usage
+}
+
+// UsageReport is the usage of a request, in total and per stage in the order stages first ran
+type UsageReport struct {
+	Total  Usage        `json:"total"`
+	Stages []StageUsage `json:"stages"`
+}
+
+// PromptReport describes what was left out of a prompt to fit the model's context window
+type PromptReport struct {
+	Stage                  string   `json:"stage"`
+	ContextWindow          int      `json:"context_window"`
+	EstimatedTokens        int      `json:"estimated_tokens"`
+	DroppedContext         []string `json:"dropped_context,omitempty"`
+	DroppedHistoryMessages int      `json:"dropped_history_messages,omitempty"`
+}
+
+// APIKeyInfo describes an API key; the key itself is only returned when it is created
+type APIKeyInfo struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Tenant     string     `json:"tenant"`
+	Prefix     string     `json:"prefix"`
+	Roles      []string   `json:"roles"`
+	UserID     *int64     `json:"user_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	// Shown only once
+	Key    string     `json:"key"`
+	APIKey APIKeyInfo `json:"api_key"`
+}
+
+type User struct {
+	ID          int64           `json:"id"`
+	Tenant      string          `json:"tenant"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"display_name,omitempty"`
+	Email       string          `json:"email,omitempty"`
+	Preferences UserPreferences `json:"preferences"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// CorpusShare grants a tenant retrieval access to another tenant's collection
+type CorpusShare struct {
+	OwnerTenant    string    `json:"owner_tenant"`
+	CollectionSlug string    `json:"collection_slug"`
+	SharedWith     string    `json:"shared_with"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// FieldError explains why a field of a request was rejected
+type FieldError struct {
+	// JSON path of the field, e.g. "options.temperature"
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is returned with every 4xx and 5xx status
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	// Machine-readable reason, e.g. "invalid_request" or "upstream_unavailable"
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Quote it when reporting a problem; it is also in the server's logs
+	RequestID string `json:"request_id,omitempty"`
+	// Set when the request body was invalid
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// ChatSocketEvent is sent by the server on the chat WebSocket
+type ChatSocketEvent struct {
+	// One of "session", "stage", "token", "done", "canceled" or "error"
+	Type string `json:"type"`
+	// Conversation the socket's messages are saved to, for session events
+	ConversationID int64 `json:"conversation_id,omitempty"`
+	// Pipeline stage and whether it "started" or "finished", for stage events
+	Stage      string `json:"stage,omitempty"`
+	Status     string `json:"status,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	// The next piece of the answer, for token events
+	Token string `json:"token,omitempty"`
+	// The full answer, for done events
+	Response *LLMResponse `json:"response,omitempty"`
+	Error    *APIError    `json:"error,omitempty"`
+}
+
+// StatusResponse acknowledges work that continues in the background
+type StatusResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,198 @@
+package prompts
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Prompt names
+const (
+	Chat          = "chat"
+	SQL           = "sql"
+	Planner       = "planner"
+	Step          = "step"
+	Synthesis     = "synthesis"
+	DataSource    = "datasource"
+	Hallucination = "hallucination"
+	Correctness   = "correctness"
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+var ErrUnknownPrompt = errors.New("unknown prompt")
+
+// Data is what prompt templates are rendered with; each prompt uses a subset
+type Data struct {
+	Question       string
+	MinSteps       int
+	MaxSteps       int
+	DataSources    []string
+	EarlierResults string
+	Unanswered     string
+	Context        string
+	Response       string
+}
+
+// Rendered is a prompt ready to be sent as a system and a user message
+type Rendered struct {
+	Name    string
+	Version string
+	System  string
+	User    string
+}
+
+// Template is a parsed prompt file. Each file defines the blocks "version",
+// "system" and "user".
+type Template struct {
+	name     string
+	version  string
+	template *template.Template
+}
+
+var funcs = template.FuncMap{"join": strings.Join}
+
+var (
+	mu       sync.RWMutex
+	registry map[string]*Template
+)
+
+// Load parses the built-in templates and then every *.tmpl file in
+// overrideDir, which replace built-in prompts of the same name. An empty
+// overrideDir only loads the built-in templates.
+func Load(overrideDir string) error {
+	loaded := make(map[string]*Template)
+
+	defaults, err := fs.Glob(defaultTemplates, "templates/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, path := range defaults {
+		content, err := defaultTemplates.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := parseInto(loaded, path, content); err != nil {
+			return err
+		}
+	}
+
+	if overrideDir != "" {
+		overrides, err := filepath.Glob(filepath.Join(overrideDir, "*.tmpl"))
+		if err != nil {
+			return err
+		}
+		for _, path := range overrides {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading prompt template: %w", err)
+			}
+			if err := parseInto(loaded, path, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	mu.Lock()
+	registry = loaded
+	mu.Unlock()
+	return nil
+}
+
+func parseInto(loaded map[string]*Template, path string, content []byte) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("error parsing prompt template %s: %w", path, err)
+	}
+	for _, block := range []string{"version", "system", "user"} {
+		if tmpl.Lookup(block) == nil {
+			return fmt.Errorf("prompt template %s does not define %q", path, block)
+		}
+	}
+
+	// Render once so that references to unknown fields fail at startup
+	for _, block := range []string{"system", "user"} {
+		if err := tmpl.ExecuteTemplate(io.Discard, block, Data{}); err != nil {
+			return fmt.Errorf("error rendering prompt template %s: %w", path, err)
+		}
+	}
+
+	var version strings.Builder
+	if err := tmpl.ExecuteTemplate(&version, "version", nil); err != nil {
+		return fmt.Errorf("error reading version of prompt template %s: %w", path, err)
+	}
+	if strings.TrimSpace(version.String()) == "" {
+		return fmt.Errorf("prompt template %s has an empty version", path)
+	}
+
+	loaded[name] = &Template{name: name, version: strings.TrimSpace(version.String()), template: tmpl}
+	return nil
+}
+
+// Render executes the system and user blocks of a prompt
+func Render(name string, data Data) (Rendered, error) {
+	tmpl, err := lookup(name)
+	if err != nil {
+		return Rendered{}, err
+	}
+
+	var system, user strings.Builder
+	if err := tmpl.template.ExecuteTemplate(&system, "system", data); err != nil {
+		return Rendered{}, fmt.Errorf("error rendering %s system prompt: %w", name, err)
+	}
+	if err := tmpl.template.ExecuteTemplate(&user, "user", data); err != nil {
+		return Rendered{}, fmt.Errorf("error rendering %s user prompt: %w", name, err)
+	}
+
+	return Rendered{
+		Name:    name,
+		Version: tmpl.version,
+		System:  strings.TrimSpace(system.String()),
+		User:    strings.TrimSpace(user.String()),
+	}, nil
+}
+
+// Versions returns the version of every loaded prompt, keyed by name
+func Versions() map[string]string {
+	ensureLoaded()
+	mu.RLock()
+	defer mu.RUnlock()
+
+	versions := make(map[string]string, len(registry))
+	for name, tmpl := range registry {
+		versions[name] = tmpl.version
+	}
+	return versions
+}
+
+func lookup(name string) (*Template, error) {
+	ensureLoaded()
+	mu.RLock()
+	defer mu.RUnlock()
+	tmpl, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownPrompt, name)
+	}
+	return tmpl, nil
+}
+
+// ensureLoaded falls back to the built-in templates when Load was never called
+func ensureLoaded() {
+	mu.RLock()
+	loaded := registry != nil
+	mu.RUnlock()
+	if !loaded {
+		if err := Load(""); err != nil {
+			panic(fmt.Sprintf("built-in prompt templates are invalid: %v", err))
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited        = errors.New("rate limit exceeded")
+	ErrConcurrencyLimited = errors.New("too many concurrent requests")
+)
+
+// Limit bounds one caller on one route; zero fields are unlimited
+type Limit struct {
+	// Sustained rate at which the token bucket refills
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	// Requests that may be made at once after being idle; defaults to one
+	// minute's worth, at least 1
+	Burst int `json:"burst"`
+	// Requests that may be in flight at the same time
+	MaxConcurrent int `json:"max_concurrent"`
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Floor(l.RequestsPerMinute))
+}
+
+// Config picks the limit of a caller on a route, from the most specific match:
+// Callers[name][route], Callers[name]["*"], Routes[route], then Default.
+// Callers are API key names or JWT subjects, routes are /v1 route patterns,
+// which also limit their legacy aliases
+type Config struct {
+	Default Limit                       `json:"default"`
+	Routes  map[string]Limit            `json:"routes"`
+	Callers map[string]map[string]Limit `json:"callers"`
+}
+
+// DefaultConfig keeps a single caller from monopolising Ollama; a multi-node
+// RAG request fans out to several model calls, so it gets the tightest limit
+var DefaultConfig = Config{
+	Default: Limit{RequestsPerMinute: 60, Burst: 20, MaxConcurrent: 4},
+	Routes: map[string]Limit{
+		"/v1/query/rag/multi": {RequestsPerMinute: 10, Burst: 3, MaxConcurrent: 2},
+	},
+}
+
+func (c Config) limitFor(caller string, route string) Limit {
+	if routes, ok := c.Callers[caller]; ok {
+		if limit, ok := routes[route]; ok {
+			return limit
+		}
+		if limit, ok := routes["*"]; ok {
+			return limit
+		}
+	}
+	if limit, ok := c.Routes[route]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+func (c Config) validate() error {
+	check := func(where string, limit Limit) error {
+		if limit.RequestsPerMinute < 0 || limit.Burst < 0 || limit.MaxConcurrent < 0 {
+			return fmt.Errorf("negative limit for %s", where)
+		}
+		return nil
+	}
+	if err := check("default", c.Default); err != nil {
+		return err
+	}
+	for route, limit := range c.Routes {
+		if err := check(route, limit); err != nil {
+			return err
+		}
+	}
+	for caller, routes := range c.Callers {
+		for route, limit := range routes {
+			if err := check(caller+" on "+route, limit); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Decision describes the state of the caller's quota after a request was admitted or refused
+type Decision struct {
+	Limit Limit
+	// Whole requests left in the token bucket
+	Remaining int
+	// Time until the bucket is full again
+	Reset time.Duration
+	// Time until the next request would be admitted, set when refused
+	RetryAfter time.Duration
+	// Concurrent requests still available, including this one's slot being taken
+	ConcurrentRemaining int
+}
+
+// sweepInterval is how often buckets that have refilled are dropped
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	// Last time the caller was seen on the route
+	updated time.Time
+	// The limit the bucket last refilled at
+	limit Limit
+}
+
+// full reports whether the bucket has refilled by now, so that dropping it
+// makes no difference
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.updated).Minutes()*b.limit.RequestsPerMinute >= b.limit.burst()
+}
+
+// Limiter enforces token-bucket rate limits and concurrency quotas per caller
+// and route. Its configuration can be replaced while it is in use
+type Limiter struct {
+	mu       sync.Mutex
+	config   Config
+	buckets  map[string]*bucket
+	inFlight map[string]int
+	now      func() time.Time
+	// Last time refilled buckets were dropped
+	swept time.Time
+}
+
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:   config,
+		buckets:  make(map[string]*bucket),
+		inFlight: make(map[string]int),
+		now:      time.Now,
+	}
+}
+
+// Config returns the limits in effect
+func (l *Limiter) Config() Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config
+}
+
+// SetConfig replaces the limits; buckets keep their tokens, capped at the new burst
+func (l *Limiter) SetConfig(config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	return nil
+}
+
+// Acquire admits a request of the caller on the route, or returns
+// ErrRateLimited or ErrConcurrencyLimited. The caller ID keys the quota, so
+// two API keys sharing a name are limited separately, while the name selects
+// the configured limit. Call release once the request is done
+func (l *Limiter) Acquire(callerID string, callerName string, route string) (_ Decision, release func(), _ error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := l.config.limitFor(callerName, route)
+	decision := Decision{Limit: limit}
+	key := callerID + " " + route
+	now := l.now()
+	l.sweep(now)
+
+	if limit.MaxConcurrent > 0 && l.inFlight[key] >= limit.MaxConcurrent {
+		decision.RetryAfter = time.Second
+		decision.Remaining, decision.Reset = l.peek(key, limit, now)
+		return decision, nil, ErrConcurrencyLimited
+	}
+
+	if limit.RequestsPerMinute > 0 {
+		b := l.refill(key, limit, now)
+		perToken := time.Duration(float64(time.Minute) / limit.RequestsPerMinute)
+		if b.tokens < 1 {
+			decision.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+			decision.Reset = time.Duration((limit.burst() - b.tokens) * float64(perToken))
+			return decision, nil, ErrRateLimited
+		}
+		b.tokens--
+		decision.Remaining = int(b.tokens)
+		decision.Reset = time.Duration((limit.burst() - b.tokens) * float64(perToken))
+	}
+
+	l.inFlight[key]++
+	if limit.MaxConcurrent > 0 {
+		decision.ConcurrentRemaining = limit.MaxConcurrent - l.inFlight[key]
+	}
+
+	var once sync.Once
+	return decision, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.inFlight[key]--; l.inFlight[key] <= 0 {
+				delete(l.inFlight, key)
+			}
+		})
+	}, nil
+}
+
+// refill tops up the bucket for the time passed since it was last used
+func (l *Limiter) refill(key string, limit Limit, now time.Time) *bucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), updated: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.updated).Minutes()
+	b.tokens = math.Min(limit.burst(), b.tokens+elapsed*limit.RequestsPerMinute)
+	b.updated = now
+	b.limit = limit
+	return b
+}
+
+// sweep drops the buckets that have refilled, at most once per sweepInterval,
+// so that callers who went away do not keep theirs forever
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) peek(key string, limit Limit, now time.Time) (int, time.Duration) {
+	if limit.RequestsPerMinute <= 0 {
+		return 0, 0
+	}
+	b := l.refill(key, limit, now)
+	perToken := float64(time.Minute) / limit.RequestsPerMinute
+	return int(b.tokens), time.Duration((limit.burst() - b.tokens) * perToken)
+}
+
+// LoadConfig reads a JSON config file; without a path it returns DefaultConfig
+func LoadConfig(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading rate limits: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("error parsing rate limits %s: %w", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid rate limits %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Watch reloads the config file whenever it changes until ctx is done. A file
+// that fails to load is logged and the previous limits stay in effect
+func (l *Limiter) Watch(ctx context.Context, path string, interval time.Duration) {
+	var modified time.Time
+	if info, err := os.Stat(path); err == nil {
+		modified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modified) {
+			continue
+		}
+		modified = info.ModTime()
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			slog.Error("Keeping previous rate limits", "error", err)
+			continue
+		}
+		if err := l.SetConfig(config); err != nil {
+			slog.Error("Keeping previous rate limits", "error", err)
+			continue
+		}
+		slog.Info("Reloaded rate limits", "path", path)
+	}
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter whose clock only moves when advance is called
+func newTestLimiter(config Config) (*Limiter, func(time.Duration)) {
+	l := NewLimiter(config)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAcquireRefill(t *testing.T) {
+	// A bucket of three that refills one token every ten seconds
+	limit := Limit{RequestsPerMinute: 6, Burst: 3}
+	tests := []struct {
+		name string
+		// Requests made at once before waiting
+		before int
+		wait   time.Duration
+		// Requests admitted at once after waiting
+		wantAdmitted int
+	}{
+		{name: "a new bucket is full", wantAdmitted: 3},
+		{name: "an empty bucket admits nothing", before: 3, wantAdmitted: 0},
+		{name: "one token refills", before: 3, wait: 10 * time.Second, wantAdmitted: 1},
+		{name: "part of a token admits nothing", before: 3, wait: 9 * time.Second, wantAdmitted: 0},
+		{name: "tokens refill in proportion", before: 3, wait: 25 * time.Second, wantAdmitted: 2},
+		{name: "refill stops at the burst", before: 2, wait: time.Hour, wantAdmitted: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, advance := newTestLimiter(Config{Default: limit})
+			for range test.before {
+				if _, _, err := l.Acquire("ada", "ada", "/v1/query/chat"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			advance(test.wait)
+
+			admitted := 0
+			for {
+				decision, _, err := l.Acquire("ada", "ada", "/v1/query/chat")
+				if errors.Is(err, ErrRateLimited) {
+					if decision.RetryAfter <= 0 || decision.RetryAfter > 10*time.Second {
+						t.Errorf("got retry after %s, want at most one token's 10s", decision.RetryAfter)
+					}
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := test.wantAdmitted - admitted - 1; decision.Remaining != want {
+					t.Errorf("got %d remaining, want %d", decision.Remaining, want)
+				}
+				admitted++
+			}
+			if admitted != test.wantAdmitted {
+				t.Errorf("admitted %d requests, want %d", admitted, test.wantAdmitted)
+			}
+		})
+	}
+}
+
+func TestAcquireConcurrency(t *testing.T) {
+	l, _ := newTestLimiter(Config{Default: Limit{MaxConcurrent: 2}})
+	_, release, err := l.Acquire("ada", "ada", "/v1/query/chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Acquire("ada", "ada", "/v1/query/chat"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Acquire("ada", "ada", "/v1/query/chat"); !errors.Is(err, ErrConcurrencyLimited) {
+		t.Fatalf("got %v for a third concurrent request, want ErrConcurrencyLimited", err)
+	}
+	if _, _, err := l.Acquire("grace", "grace", "/v1/query/chat"); err != nil {
+		t.Fatalf("another caller was limited: %v", err)
+	}
+	// Releasing twice frees a single slot
+	release()
+	release()
+	if _, _, err := l.Acquire("ada", "ada", "/v1/query/chat"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Acquire("ada", "ada", "/v1/query/chat"); !errors.Is(err, ErrConcurrencyLimited) {
+		t.Fatalf("got %v after a double release, want ErrConcurrencyLimited", err)
+	}
+}
+
+func TestLimitFor(t *testing.T) {
+	config := Config{
+		Default: Limit{RequestsPerMinute: 1},
+		Routes:  map[string]Limit{"/v1/query/rag/multi": {RequestsPerMinute: 2}},
+		Callers: map[string]map[string]Limit{
+			"ada": {"/v1/query/sql": {RequestsPerMinute: 3}, "*": {RequestsPerMinute: 4}},
+		},
+	}
+	tests := []struct {
+		caller, route string
+		want          float64
+	}{
+		{"ada", "/v1/query/sql", 3},
+		{"ada", "/v1/query/rag/multi", 4},
+		{"grace", "/v1/query/rag/multi", 2},
+		{"grace", "/v1/query/chat", 1},
+	}
+	for _, test := range tests {
+		if got := config.limitFor(test.caller, test.route).RequestsPerMinute; got != test.want {
+			t.Errorf("limit of %s on %s is %v requests per minute, want %v", test.caller, test.route, got, test.want)
+		}
+	}
+}
+
+func TestSweepDropsRefilledBuckets(t *testing.T) {
+	l, advance := newTestLimiter(Config{Default: Limit{RequestsPerMinute: 1, Burst: 10}})
+	acquire := func(caller string) {
+		t.Helper()
+		_, release, err := l.Acquire(caller, caller, "/v1/query/chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+
+	// The idle caller is one token short, the busy caller ten
+	acquire("idle")
+	for range 10 {
+		acquire("busy")
+	}
+	// Two minutes later only the idle caller's bucket is full again
+	advance(2 * time.Minute)
+	acquire("other")
+
+	if _, ok := l.buckets["idle /v1/query/chat"]; ok {
+		t.Error("the refilled bucket of the idle caller was kept")
+	}
+	if _, ok := l.buckets["busy /v1/query/chat"]; !ok {
+		t.Error("the bucket of the busy caller was dropped before it refilled")
+	}
+}
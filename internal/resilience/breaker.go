@@ -0,0 +1,99 @@
+package resilience
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// CircuitBreaker stops calls to a dependency after consecutive failures and
+// lets a single trial call through once the cooldown has passed
+type CircuitBreaker struct {
+	name             string
+	failureThreshold int
+	cooldown         time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func NewCircuitBreaker(name string, failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		name:             name,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+	}
+}
+
+// Allow returns ErrCircuitOpen while the dependency is considered down, and
+// reports whether the call it lets through is the trial call
+func (b *CircuitBreaker) Allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+		}
+		b.state = stateHalfOpen
+		return true, nil
+	case stateHalfOpen:
+		// Only the trial call may go through
+		return false, fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+	default:
+		return false, nil
+	}
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateClosed
+	b.failures = 0
+}
+
+// Abandon gives up the trial call without learning anything about the
+// dependency, such as when its caller canceled it. The next call is the trial
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.state = stateOpen
+		b.openedAt = time.Now().Add(-b.cooldown)
+	}
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != stateOpen {
+			slog.Warn("Circuit breaker opened", "service", b.name, "failures", b.failures, "cooldown", b.cooldown)
+		}
+		b.state = stateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Open reports whether calls are currently being rejected
+func (b *CircuitBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == stateOpen && time.Since(b.openedAt) < b.cooldown
+}
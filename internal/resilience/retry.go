@@ -0,0 +1,127 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Policy controls how often and how quickly a call is retried
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultPolicy = Policy{
+	MaxAttempts: 4,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// StatusError is returned for unexpected HTTP responses from a dependency
+type StatusError struct {
+	Service    string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.Service, e.StatusCode, e.Message)
+}
+
+// Retryable reports whether the dependency may succeed if asked again
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsRetryable reports whether err is a transient failure: a connection error,
+// a truncated response, or a 5xx/429 status from the dependency
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Do calls fn until it succeeds, returns a permanent error, the policy runs out
+// of attempts or ctx is done. Answers and failures of the dependency are
+// reported to the breaker, calls ended by ctx are not, and no call is made
+// while the breaker is open.
+func Do(ctx context.Context, breaker *CircuitBreaker, policy Policy, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			delay := backoff(policy, attempt)
+			slog.WarnContext(ctx, "Retrying after error", "service", breaker.name, "attempt", attempt+1, "delay", delay, "error", err)
+			if waitErr := sleep(ctx, delay); waitErr != nil {
+				return fmt.Errorf("%w (last error: %w)", waitErr, err)
+			}
+		}
+
+		trial, allowErr := breaker.Allow()
+		if allowErr != nil {
+			if err != nil {
+				return fmt.Errorf("%w (last error: %w)", allowErr, err)
+			}
+			return allowErr
+		}
+
+		err = fn(ctx)
+		if err == nil {
+			breaker.Success()
+			return nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the dependency
+			if trial {
+				breaker.Abandon()
+			}
+			return err
+		}
+		if !IsRetryable(err) {
+			// The dependency answered, it just did not like the request
+			breaker.Success()
+			return err
+		}
+		breaker.Failure()
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", policy.MaxAttempts, err)
+}
+
+// backoff returns a full-jitter exponential delay for the given retry
+func backoff(policy Policy, attempt int) time.Duration {
+	ceiling := policy.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > policy.MaxDelay {
+		ceiling = policy.MaxDelay
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
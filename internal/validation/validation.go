@@ -0,0 +1,270 @@
+package validation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/config"
+	"orchestrator/internal/database"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/models"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxSearchLimit bounds the results retrieved per data source, since they all
+// end up in the prompt
+const MaxSearchLimit = 50
+
+// Error lists every invalid field of a request
+type Error struct {
+	Fields []models.FieldError
+}
+
+func (e *Error) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// checker collects field errors while a request is normalized
+type checker struct {
+	fields []models.FieldError
+}
+
+func (c *checker) fail(field string, format string, args ...any) {
+	c.fields = append(c.fields, models.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) required(field string, value *string) {
+	*value = strings.TrimSpace(*value)
+	if *value == "" {
+		c.fail(field, "is required")
+	}
+}
+
+// input checks that the query is present and short enough to go in a prompt
+func (c *checker) input(value *string) {
+	c.required("input", value)
+	if maxLength := config.Get().HTTP.MaxInputLength; utf8.RuneCountInString(*value) > maxLength {
+		c.fail("input", "must be at most %d characters", maxLength)
+	}
+}
+
+func (c *checker) err() error {
+	if len(c.fields) == 0 {
+		return nil
+	}
+	return &Error{Fields: c.fields}
+}
+
+func defaultString(value *string, fallback string) {
+	if *value = strings.TrimSpace(*value); *value == "" {
+		*value = fallback
+	}
+}
+
+// defaults are the configured request defaults with the caller's preferences applied
+func defaults(ctx context.Context) config.Defaults {
+	result := config.Get().Defaults
+	identity, _ := auth.FromContext(ctx)
+	preferences := identity.Preferences
+	if preferences.ChatModel != "" {
+		result.ChatModel = preferences.ChatModel
+	}
+	if preferences.EmbeddingModel != "" {
+		result.EmbeddingModel = preferences.EmbeddingModel
+	}
+	if preferences.SearchLimit != 0 {
+		result.SearchLimit = preferences.SearchLimit
+	}
+	return result
+}
+
+// DocumentEmbeddingsRequest fills in the embedding model and checks the CID
+func DocumentEmbeddingsRequest(ctx context.Context, request *models.DocumentEmbeddingsRequest) error {
+	var c checker
+	c.required("cid", &request.CID)
+	request.CollectionSlug = strings.TrimSpace(request.CollectionSlug)
+	defaultString(&request.Model, defaults(ctx).EmbeddingModel)
+	return c.err()
+}
+
+// RowEmbeddingsRequest fills in the embedding model and checks the table and primary key
+func RowEmbeddingsRequest(ctx context.Context, request *models.RowEmbeddingsRequest) error {
+	var c checker
+	c.required("table", &request.Table)
+	if request.Table != "" && !slices.Contains(database.TableNames, request.Table) {
+		c.fail("table", "must be one of %s", strings.Join(database.TableNames, ", "))
+	}
+	if key := bytes.TrimSpace(request.RowPrimaryKey); len(key) == 0 || bytes.Equal(key, []byte("null")) {
+		c.fail("row_primary_key", "is required")
+	}
+	defaultString(&request.Model, defaults(ctx).EmbeddingModel)
+	return c.err()
+}
+
+// LLMSimpleQueryRequest fills in the model and checks the input and options
+func LLMSimpleQueryRequest(ctx context.Context, request *models.LLMSimpleQueryRequest) error {
+	var c checker
+	c.input(&request.Input)
+	defaultString(&request.Model, defaults(ctx).ChatModel)
+	c.conversationID(request.ConversationID)
+	c.options("options", request.Options)
+	return c.err()
+}
+
+// LLMSQLQueryRequest fills in the model and checks the input and options
+func LLMSQLQueryRequest(ctx context.Context, request *models.LLMSQLQueryRequest) error {
+	var c checker
+	c.input(&request.Input)
+	defaultString(&request.Model, defaults(ctx).ChatModel)
+	c.conversationID(request.ConversationID)
+	c.options("options", request.Options)
+	return c.err()
+}
+
+// LLMRAGQueryRequest fills in the models, search limit and sub-question bounds,
+// and checks the input, data sources and options
+func LLMRAGQueryRequest(ctx context.Context, request *models.LLMRAGQueryRequest) error {
+	var c checker
+	c.input(&request.Input)
+	defaultString(&request.Model, defaults(ctx).ChatModel)
+	defaultString(&request.EmbeddingModel, defaults(ctx).EmbeddingModel)
+	c.conversationID(request.ConversationID)
+
+	if request.SearchLimit == 0 {
+		request.SearchLimit = defaults(ctx).SearchLimit
+	}
+	if request.SearchLimit < 1 || request.SearchLimit > MaxSearchLimit {
+		c.fail("search_limit", "must be between 1 and %d", MaxSearchLimit)
+	}
+
+	if request.MinSubQuestions < 0 {
+		c.fail("min_sub_questions", "may not be negative")
+	}
+	if request.MaxSubQuestions < 0 {
+		c.fail("max_sub_questions", "may not be negative")
+	}
+	if request.MinSubQuestions >= 0 && request.MaxSubQuestions >= 0 {
+		minQuestions, maxQuestions, err := llm.SubQuestionBounds(*request)
+		if err != nil {
+			c.fail("max_sub_questions", "%s", err)
+		} else {
+			request.MinSubQuestions, request.MaxSubQuestions = minQuestions, maxQuestions
+		}
+	}
+
+	for i, source := range request.DataSources {
+		request.DataSources[i] = strings.ToLower(strings.TrimSpace(source))
+		if !slices.Contains(llm.AllDataSources, request.DataSources[i]) {
+			c.fail(fmt.Sprintf("data_sources[%d]", i), "must be one of %s", strings.Join(llm.AllDataSources, ", "))
+		}
+	}
+
+	c.options("options", request.Options)
+	stages := make([]string, 0, len(request.StageOptions))
+	for stage := range request.StageOptions {
+		stages = append(stages, stage)
+	}
+	slices.Sort(stages)
+	for _, stage := range stages {
+		options := request.StageOptions[stage]
+		field := "stage_options." + stage
+		if !slices.Contains(llm.ConfigurableStages, llm.Stage(stage)) {
+			c.fail(field, "is not a stage with options")
+			continue
+		}
+		c.options(field, &options)
+	}
+	return c.err()
+}
+
+// CorpusShareRequest checks that the collection is shared with another tenant
+// than the caller's
+func CorpusShareRequest(ctx context.Context, request *models.CorpusShareRequest) error {
+	var c checker
+	c.required("collection_slug", &request.CollectionSlug)
+	c.required("shared_with", &request.SharedWith)
+	if identity, _ := auth.FromContext(ctx); request.SharedWith != "" && request.SharedWith == identity.Tenant {
+		c.fail("shared_with", "must be another tenant than your own")
+	}
+	return c.err()
+}
+
+// CreateUserRequest checks the name and preferences of a new user
+func CreateUserRequest(_ context.Context, request *models.CreateUserRequest) error {
+	var c checker
+	c.required("name", &request.Name)
+	request.Tenant = strings.TrimSpace(request.Tenant)
+	request.Email = strings.TrimSpace(request.Email)
+	c.preferences("preferences", &request.Preferences)
+	return c.err()
+}
+
+// UpdateUserRequest checks the preferences, if they are changed
+func UpdateUserRequest(_ context.Context, request *models.UpdateUserRequest) error {
+	var c checker
+	if request.Email != nil {
+		*request.Email = strings.TrimSpace(*request.Email)
+	}
+	if request.Preferences != nil {
+		c.preferences("preferences", request.Preferences)
+	}
+	return c.err()
+}
+
+// UserPreferences checks preferences set on their own
+func UserPreferences(_ context.Context, preferences *models.UserPreferences) error {
+	var c checker
+	c.preferences("", preferences)
+	return c.err()
+}
+
+// preferences checks that the defaults a user prefers would pass validation
+// themselves; empty fields leave the configured default in place
+func (c *checker) preferences(field string, preferences *models.UserPreferences) {
+	if field != "" {
+		field += "."
+	}
+	preferences.ChatModel = strings.TrimSpace(preferences.ChatModel)
+	preferences.EmbeddingModel = strings.TrimSpace(preferences.EmbeddingModel)
+	if preferences.SearchLimit < 0 || preferences.SearchLimit > MaxSearchLimit {
+		c.fail(field+"search_limit", "must be between 1 and %d, or 0 for the default", MaxSearchLimit)
+	}
+}
+
+func (c *checker) conversationID(id int64) {
+	if id < 0 {
+		c.fail("conversation_id", "may not be negative")
+	}
+}
+
+// options checks generation options against the ranges Ollama accepts
+func (c *checker) options(field string, options *models.GenerationOptions) {
+	if options == nil {
+		return
+	}
+	if t := options.Temperature; t != nil && (*t < 0 || *t > 2) {
+		c.fail(field+".temperature", "must be between 0 and 2")
+	}
+	if p := options.TopP; p != nil && (*p <= 0 || *p > 1) {
+		c.fail(field+".top_p", "must be greater than 0 and at most 1")
+	}
+	if n := options.NumCtx; n != nil && *n <= 0 {
+		c.fail(field+".num_ctx", "must be positive")
+	}
+	if len(options.Format) > 0 {
+		var format any
+		if err := json.Unmarshal(options.Format, &format); err != nil {
+			c.fail(field+".format", "must be valid JSON")
+		} else if _, isSchema := format.(map[string]any); !isSchema && format != "json" {
+			c.fail(field+".format", `must be "json" or a JSON schema`)
+		}
+	}
+}
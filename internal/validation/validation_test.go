@@ -0,0 +1,182 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"orchestrator/internal/auth"
+	"orchestrator/internal/llm"
+	"orchestrator/internal/models"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fieldsOf returns the fields named by a validation error
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var validationErr *Error
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	var fields []string
+	for _, field := range validationErr.Fields {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+func TestLLMRAGQueryRequestDefaults(t *testing.T) {
+	preferring := auth.WithIdentity(context.Background(), auth.Identity{
+		Subject:     "ada",
+		Preferences: models.UserPreferences{ChatModel: "mistral", EmbeddingModel: "mxbai-embed-large", SearchLimit: 9},
+	})
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		request models.LLMRAGQueryRequest
+		want    models.LLMRAGQueryRequest
+	}{
+		{
+			name:    "configured defaults",
+			ctx:     context.Background(),
+			request: models.LLMRAGQueryRequest{Input: " what changed? "},
+			want: models.LLMRAGQueryRequest{
+				Input: "what changed?", Model: "llama3", EmbeddingModel: "nomic-embed-text", SearchLimit: 5,
+				MinSubQuestions: llm.DefaultMinSubQuestions, MaxSubQuestions: llm.DefaultMaxSubQuestions,
+			},
+		},
+		{
+			name:    "preferences of the caller",
+			ctx:     preferring,
+			request: models.LLMRAGQueryRequest{Input: "what changed?"},
+			want: models.LLMRAGQueryRequest{
+				Input: "what changed?", Model: "mistral", EmbeddingModel: "mxbai-embed-large", SearchLimit: 9,
+				MinSubQuestions: llm.DefaultMinSubQuestions, MaxSubQuestions: llm.DefaultMaxSubQuestions,
+			},
+		},
+		{
+			name: "request values win over preferences",
+			ctx:  preferring,
+			request: models.LLMRAGQueryRequest{
+				Input: "what changed?", Model: " phi3 ", EmbeddingModel: "all-minilm", SearchLimit: 3,
+				MinSubQuestions: 1, MaxSubQuestions: 2, DataSources: []string{" SQL "},
+			},
+			want: models.LLMRAGQueryRequest{
+				Input: "what changed?", Model: "phi3", EmbeddingModel: "all-minilm", SearchLimit: 3,
+				MinSubQuestions: 1, MaxSubQuestions: 2, DataSources: []string{"sql"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := test.request
+			if err := LLMRAGQueryRequest(test.ctx, &request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, want := request, test.want
+			if !slices.Equal(got.DataSources, want.DataSources) {
+				t.Errorf("got data sources %v, want %v", got.DataSources, want.DataSources)
+			}
+			got.DataSources, want.DataSources = nil, nil
+			if got.Input != want.Input || got.Model != want.Model || got.EmbeddingModel != want.EmbeddingModel ||
+				got.SearchLimit != want.SearchLimit || got.MinSubQuestions != want.MinSubQuestions || got.MaxSubQuestions != want.MaxSubQuestions {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLLMRAGQueryRequestErrors(t *testing.T) {
+	temperature := 3.0
+	tests := []struct {
+		name       string
+		request    models.LLMRAGQueryRequest
+		wantFields []string
+	}{
+		{name: "valid", request: models.LLMRAGQueryRequest{Input: "q"}},
+		{name: "blank input", request: models.LLMRAGQueryRequest{Input: "  "}, wantFields: []string{"input"}},
+		{name: "input over the limit", request: models.LLMRAGQueryRequest{Input: strings.Repeat("q", 4001)}, wantFields: []string{"input"}},
+		{name: "search limit over the maximum", request: models.LLMRAGQueryRequest{Input: "q", SearchLimit: MaxSearchLimit + 1}, wantFields: []string{"search_limit"}},
+		{name: "negative search limit", request: models.LLMRAGQueryRequest{Input: "q", SearchLimit: -1}, wantFields: []string{"search_limit"}},
+		{name: "negative conversation", request: models.LLMRAGQueryRequest{Input: "q", ConversationID: -1}, wantFields: []string{"conversation_id"}},
+		{name: "minimum above maximum", request: models.LLMRAGQueryRequest{Input: "q", MinSubQuestions: 4, MaxSubQuestions: 3}, wantFields: []string{"max_sub_questions"}},
+		{name: "negative minimum", request: models.LLMRAGQueryRequest{Input: "q", MinSubQuestions: -1}, wantFields: []string{"min_sub_questions"}},
+		{name: "unknown data source", request: models.LLMRAGQueryRequest{Input: "q", DataSources: []string{"sql", "web"}}, wantFields: []string{"data_sources[1]"}},
+		{name: "options out of range", request: models.LLMRAGQueryRequest{Input: "q", Options: &models.GenerationOptions{Temperature: &temperature}}, wantFields: []string{"options.temperature"}},
+		{
+			name: "stage options",
+			request: models.LLMRAGQueryRequest{Input: "q", StageOptions: map[string]models.GenerationOptions{
+				"synthesis": {Temperature: &temperature},
+				"embedding": {},
+			}},
+			wantFields: []string{"stage_options.embedding", "stage_options.synthesis.temperature"},
+		},
+		{
+			name:       "every invalid field",
+			request:    models.LLMRAGQueryRequest{SearchLimit: -1, ConversationID: -1},
+			wantFields: []string{"input", "conversation_id", "search_limit"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := test.request
+			fields := fieldsOf(t, LLMRAGQueryRequest(context.Background(), &request))
+			if !slices.Equal(fields, test.wantFields) {
+				t.Errorf("got invalid fields %v, want %v", fields, test.wantFields)
+			}
+		})
+	}
+}
+
+func TestEmbeddingsRequestDefaults(t *testing.T) {
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{Preferences: models.UserPreferences{EmbeddingModel: "mxbai-embed-large"}})
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		model     string
+		wantModel string
+	}{
+		{name: "configured default", ctx: context.Background(), wantModel: "nomic-embed-text"},
+		{name: "preference of the caller", ctx: ctx, wantModel: "mxbai-embed-large"},
+		{name: "model of the request", ctx: ctx, model: "all-minilm", wantModel: "all-minilm"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := models.DocumentEmbeddingsRequest{CID: "bafy", Model: test.model}
+			if err := DocumentEmbeddingsRequest(test.ctx, &request); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if request.Model != test.wantModel {
+				t.Errorf("got model %q, want %q", request.Model, test.wantModel)
+			}
+		})
+	}
+}
+
+func TestUserPreferences(t *testing.T) {
+	tests := []struct {
+		name       string
+		preference models.UserPreferences
+		wantFields []string
+	}{
+		{name: "unset", preference: models.UserPreferences{}},
+		{name: "in range", preference: models.UserPreferences{ChatModel: " mistral ", SearchLimit: MaxSearchLimit}},
+		{name: "search limit over the maximum", preference: models.UserPreferences{SearchLimit: MaxSearchLimit + 1}, wantFields: []string{"search_limit"}},
+		{name: "negative search limit", preference: models.UserPreferences{SearchLimit: -1}, wantFields: []string{"search_limit"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			preferences := test.preference
+			fields := fieldsOf(t, UserPreferences(context.Background(), &preferences))
+			if !slices.Equal(fields, test.wantFields) {
+				t.Errorf("got invalid fields %v, want %v", fields, test.wantFields)
+			}
+			if preferences.ChatModel != strings.TrimSpace(preferences.ChatModel) {
+				t.Errorf("chat model %q was not trimmed", preferences.ChatModel)
+			}
+		})
+	}
+}
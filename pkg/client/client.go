@@ -0,0 +1,240 @@
+// Package client calls the orchestrator's HTTP API
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"orchestrator/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client authenticates every request with an API key or JWT
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout. LLM
+// queries can take minutes, so keep any timeout generous
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New returns a client for the orchestrator at baseURL, e.g. http://orchestrator:8080,
+// sending token as a bearer token
+func New(baseURL string, token string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: http.DefaultClient,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Error is returned for responses with a 4xx or 5xx status
+type Error struct {
+	StatusCode int
+	// Machine-readable reason, e.g. "invalid_request" or "upstream_unavailable"
+	Code    string
+	Message string
+	// Identifies the request in the orchestrator's logs
+	RequestID string
+	// Invalid fields of the request, for 400 responses
+	Fields []FieldError
+	// How long to wait before retrying, for 429 responses
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("orchestrator returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+	for _, field := range e.Fields {
+		message += fmt.Sprintf("; %s %s", field.Field, field.Message)
+	}
+	return message
+}
+
+func (c *Client) SimpleQuery(ctx context.Context, request LLMSimpleQueryRequest) (LLMResponse, error) {
+	var response LLMResponse
+	err := c.do(ctx, http.MethodPost, "/v1/query/chat", request, &response)
+	return response, err
+}
+
+// RAGQuery answers from the documents and rows most similar to the input
+func (c *Client) RAGQuery(ctx context.Context, request LLMRAGQueryRequest) (LLMResponse, error) {
+	var response LLMResponse
+	err := c.do(ctx, http.MethodPost, "/v1/query/rag", request, &response)
+	return response, err
+}
+
+// MultiNodeRAGQuery splits the input into sub-questions answered from
+// different data sources; set Debug to get the trace of how it did so
+func (c *Client) MultiNodeRAGQuery(ctx context.Context, request LLMRAGQueryRequest) (LLMRAGQueryResponse, error) {
+	var response LLMRAGQueryResponse
+	err := c.do(ctx, http.MethodPost, "/v1/query/rag/multi", request, &response)
+	return response, err
+}
+
+func (c *Client) SQLQuery(ctx context.Context, request LLMSQLQueryRequest) (LLMResponse, error) {
+	var response LLMResponse
+	err := c.do(ctx, http.MethodPost, "/v1/query/sql", request, &response)
+	return response, err
+}
+
+// EmbedRow queues a row for embedding; it returns before the row is embedded
+func (c *Client) EmbedRow(ctx context.Context, request RowEmbeddingsRequest) error {
+	return c.do(ctx, http.MethodPost, "/v1/embeddings/rows", request, nil)
+}
+
+// EmbedDocument queues a document on IPFS for embedding; it returns before the document is embedded
+func (c *Client) EmbedDocument(ctx context.Context, request DocumentEmbeddingsRequest) error {
+	return c.do(ctx, http.MethodPost, "/v1/embeddings/documents", request, nil)
+}
+
+func (c *Client) ListShares(ctx context.Context) ([]CorpusShare, error) {
+	var response []CorpusShare
+	err := c.do(ctx, http.MethodGet, "/v1/shares", nil, &response)
+	return response, err
+}
+
+func (c *Client) ShareCollection(ctx context.Context, request CorpusShareRequest) (CorpusShare, error) {
+	var response CorpusShare
+	err := c.do(ctx, http.MethodPost, "/v1/shares", request, &response)
+	return response, err
+}
+
+func (c *Client) UnshareCollection(ctx context.Context, request CorpusShareRequest) error {
+	return c.do(ctx, http.MethodDelete, "/v1/shares", request, nil)
+}
+
+func (c *Client) CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (CreateAPIKeyResponse, error) {
+	var response CreateAPIKeyResponse
+	err := c.do(ctx, http.MethodPost, "/v1/admin/keys", request, &response)
+	return response, err
+}
+
+func (c *Client) ListAPIKeys(ctx context.Context) ([]APIKeyInfo, error) {
+	var response []APIKeyInfo
+	err := c.do(ctx, http.MethodGet, "/v1/admin/keys", nil, &response)
+	return response, err
+}
+
+func (c *Client) RevokeAPIKey(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/v1/admin/keys/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
+// CurrentUser returns the user behind the client's credentials
+func (c *Client) CurrentUser(ctx context.Context) (User, error) {
+	var response User
+	err := c.do(ctx, http.MethodGet, "/v1/me", nil, &response)
+	return response, err
+}
+
+// SetPreferences replaces the request defaults of the client's user
+func (c *Client) SetPreferences(ctx context.Context, preferences UserPreferences) (User, error) {
+	var response User
+	err := c.do(ctx, http.MethodPut, "/v1/me/preferences", preferences, &response)
+	return response, err
+}
+
+func (c *Client) CreateUser(ctx context.Context, request CreateUserRequest) (User, error) {
+	var response User
+	err := c.do(ctx, http.MethodPost, "/v1/admin/users", request, &response)
+	return response, err
+}
+
+func (c *Client) ListUsers(ctx context.Context) ([]User, error) {
+	var response []User
+	err := c.do(ctx, http.MethodGet, "/v1/admin/users", nil, &response)
+	return response, err
+}
+
+func (c *Client) GetUser(ctx context.Context, id int64) (User, error) {
+	var response User
+	err := c.do(ctx, http.MethodGet, "/v1/admin/users/"+strconv.FormatInt(id, 10), nil, &response)
+	return response, err
+}
+
+func (c *Client) UpdateUser(ctx context.Context, id int64, request UpdateUserRequest) (User, error) {
+	var response User
+	err := c.do(ctx, http.MethodPatch, "/v1/admin/users/"+strconv.FormatInt(id, 10), request, &response)
+	return response, err
+}
+
+// DeleteUser deletes a user and revokes their API keys
+func (c *Client) DeleteUser(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/v1/admin/users/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
+// do sends request as JSON and decodes the response into response, unless it is nil
+func (c *Client) do(ctx context.Context, method string, path string, request any, response any) error {
+	var body io.Reader
+	if request != nil {
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("error marshaling request: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return responseError(resp)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var body models.ErrorResponse
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(data, &body) == nil && body.Error.Code != "" {
+		e.Code = body.Error.Code
+		e.Message = body.Error.Message
+		e.RequestID = body.Error.RequestID
+		e.Fields = body.Error.Fields
+	}
+	e.RequestID = cmp.Or(e.RequestID, resp.Header.Get("X-Request-ID"))
+	return e
+}
@@ -0,0 +1,36 @@
+package client
+
+import "orchestrator/internal/models"
+
+// The request and response types are those of the server, re-exported so
+// that services outside this module can name them
+
+type (
+	LLMSimpleQueryRequest     = models.LLMSimpleQueryRequest
+	LLMRAGQueryRequest        = models.LLMRAGQueryRequest
+	LLMSQLQueryRequest        = models.LLMSQLQueryRequest
+	GenerationOptions         = models.GenerationOptions
+	LLMResponse               = models.LLMResponse
+	LLMRAGQueryResponse       = models.LLMRAGQueryResponse
+	RAGTrace                  = models.RAGTrace
+	SubResult                 = models.SubResult
+	Source                    = models.Source
+	Usage                     = models.Usage
+	StageUsage                = models.StageUsage
+	UsageReport               = models.UsageReport
+	PromptReport              = models.PromptReport
+	RowEmbeddingsRequest      = models.RowEmbeddingsRequest
+	DocumentEmbeddingsRequest = models.DocumentEmbeddingsRequest
+	StatusResponse            = models.StatusResponse
+	CorpusShareRequest        = models.CorpusShareRequest
+	CorpusShare               = models.CorpusShare
+	CreateAPIKeyRequest       = models.CreateAPIKeyRequest
+	CreateAPIKeyResponse      = models.CreateAPIKeyResponse
+	APIKeyInfo                = models.APIKeyInfo
+	User                      = models.User
+	UserPreferences           = models.UserPreferences
+	CreateUserRequest         = models.CreateUserRequest
+	UpdateUserRequest         = models.UpdateUserRequest
+	FieldError                = models.FieldError
+	APIError                  = models.APIError
+)
@@ -0,0 +1,346 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: orchestrator/v1/orchestrator.proto
+
+package orchestratorv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Orchestrator_EmbedRow_FullMethodName          = "/orchestrator.v1.Orchestrator/EmbedRow"
+	Orchestrator_EmbedDocument_FullMethodName     = "/orchestrator.v1.Orchestrator/EmbedDocument"
+	Orchestrator_Chat_FullMethodName              = "/orchestrator.v1.Orchestrator/Chat"
+	Orchestrator_RAGQuery_FullMethodName          = "/orchestrator.v1.Orchestrator/RAGQuery"
+	Orchestrator_MultiNodeRAGQuery_FullMethodName = "/orchestrator.v1.Orchestrator/MultiNodeRAGQuery"
+	Orchestrator_SQLQuery_FullMethodName          = "/orchestrator.v1.Orchestrator/SQLQuery"
+)
+
+// OrchestratorClient is the client API for Orchestrator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Orchestrator serves the embedding and query endpoints of the REST API to
+// internal services. Calls authenticate with an API key or JWT sent in the
+// "authorization" ("Bearer <token>") or "x-api-key" metadata, need the same
+// roles as their REST routes and share their rate limits.
+type OrchestratorClient interface {
+	// EmbedRow embeds a table row and returns once the embedding is stored
+	EmbedRow(ctx context.Context, in *RowEmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error)
+	// EmbedDocument embeds a document from IPFS and returns once every chunk is stored
+	EmbedDocument(ctx context.Context, in *DocumentEmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error)
+	// Chat streams the answer of a chat query token by token, then sends the
+	// full response
+	Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (Orchestrator_ChatClient, error)
+	RAGQuery(ctx context.Context, in *RAGQueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	MultiNodeRAGQuery(ctx context.Context, in *RAGQueryRequest, opts ...grpc.CallOption) (*MultiNodeRAGQueryResponse, error)
+	SQLQuery(ctx context.Context, in *SQLQueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+}
+
+type orchestratorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrchestratorClient(cc grpc.ClientConnInterface) OrchestratorClient {
+	return &orchestratorClient{cc}
+}
+
+func (c *orchestratorClient) EmbedRow(ctx context.Context, in *RowEmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EmbeddingsResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_EmbedRow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) EmbedDocument(ctx context.Context, in *DocumentEmbeddingsRequest, opts ...grpc.CallOption) (*EmbeddingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EmbeddingsResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_EmbedDocument_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) Chat(ctx context.Context, in *ChatRequest, opts ...grpc.CallOption) (Orchestrator_ChatClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Orchestrator_ServiceDesc.Streams[0], Orchestrator_Chat_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orchestratorChatClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Orchestrator_ChatClient interface {
+	Recv() (*ChatResponse, error)
+	grpc.ClientStream
+}
+
+type orchestratorChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *orchestratorChatClient) Recv() (*ChatResponse, error) {
+	m := new(ChatResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *orchestratorClient) RAGQuery(ctx context.Context, in *RAGQueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_RAGQuery_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) MultiNodeRAGQuery(ctx context.Context, in *RAGQueryRequest, opts ...grpc.CallOption) (*MultiNodeRAGQueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MultiNodeRAGQueryResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_MultiNodeRAGQuery_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orchestratorClient) SQLQuery(ctx context.Context, in *SQLQueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, Orchestrator_SQLQuery_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// OrchestratorServer is the server API for Orchestrator service.
+// All implementations must embed UnimplementedOrchestratorServer
+// for forward compatibility
+//
+// Orchestrator serves the embedding and query endpoints of the REST API to
+// internal services. Calls authenticate with an API key or JWT sent in the
+// "authorization" ("Bearer <token>") or "x-api-key" metadata, need the same
+// roles as their REST routes and share their rate limits.
+type OrchestratorServer interface {
+	// EmbedRow embeds a table row and returns once the embedding is stored
+	EmbedRow(context.Context, *RowEmbeddingsRequest) (*EmbeddingsResponse, error)
+	// EmbedDocument embeds a document from IPFS and returns once every chunk is stored
+	EmbedDocument(context.Context, *DocumentEmbeddingsRequest) (*EmbeddingsResponse, error)
+	// Chat streams the answer of a chat query token by token, then sends the
+	// full response
+	Chat(*ChatRequest, Orchestrator_ChatServer) error
+	RAGQuery(context.Context, *RAGQueryRequest) (*QueryResponse, error)
+	MultiNodeRAGQuery(context.Context, *RAGQueryRequest) (*MultiNodeRAGQueryResponse, error)
+	SQLQuery(context.Context, *SQLQueryRequest) (*QueryResponse, error)
+	mustEmbedUnimplementedOrchestratorServer()
+}
+
+// UnimplementedOrchestratorServer must be embedded to have forward compatible implementations.
+type UnimplementedOrchestratorServer struct {
+}
+
+func (UnimplementedOrchestratorServer) EmbedRow(context.Context, *RowEmbeddingsRequest) (*EmbeddingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EmbedRow not implemented")
+}
+func (UnimplementedOrchestratorServer) EmbedDocument(context.Context, *DocumentEmbeddingsRequest) (*EmbeddingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EmbedDocument not implemented")
+}
+func (UnimplementedOrchestratorServer) Chat(*ChatRequest, Orchestrator_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedOrchestratorServer) RAGQuery(context.Context, *RAGQueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RAGQuery not implemented")
+}
+func (UnimplementedOrchestratorServer) MultiNodeRAGQuery(context.Context, *RAGQueryRequest) (*MultiNodeRAGQueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MultiNodeRAGQuery not implemented")
+}
+func (UnimplementedOrchestratorServer) SQLQuery(context.Context, *SQLQueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SQLQuery not implemented")
+}
+func (UnimplementedOrchestratorServer) mustEmbedUnimplementedOrchestratorServer() {}
+
+// UnsafeOrchestratorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrchestratorServer will
+// result in compilation errors.
+type UnsafeOrchestratorServer interface {
+	mustEmbedUnimplementedOrchestratorServer()
+}
+
+func RegisterOrchestratorServer(s grpc.ServiceRegistrar, srv OrchestratorServer) {
+	s.RegisterService(&Orchestrator_ServiceDesc, srv)
+}
+
+func _Orchestrator_EmbedRow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RowEmbeddingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).EmbedRow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_EmbedRow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).EmbedRow(ctx, req.(*RowEmbeddingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_EmbedDocument_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DocumentEmbeddingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).EmbedDocument(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_EmbedDocument_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).EmbedDocument(ctx, req.(*DocumentEmbeddingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChatRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrchestratorServer).Chat(m, &orchestratorChatServer{ServerStream: stream})
+}
+
+type Orchestrator_ChatServer interface {
+	Send(*ChatResponse) error
+	grpc.ServerStream
+}
+
+type orchestratorChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *orchestratorChatServer) Send(m *ChatResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Orchestrator_RAGQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RAGQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).RAGQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_RAGQuery_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).RAGQuery(ctx, req.(*RAGQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_MultiNodeRAGQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RAGQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).MultiNodeRAGQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_MultiNodeRAGQuery_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).MultiNodeRAGQuery(ctx, req.(*RAGQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Orchestrator_SQLQuery_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SQLQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrchestratorServer).SQLQuery(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Orchestrator_SQLQuery_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrchestratorServer).SQLQuery(ctx, req.(*SQLQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Orchestrator_ServiceDesc is the grpc.ServiceDesc for Orchestrator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Orchestrator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "orchestrator.v1.Orchestrator",
+	HandlerType: (*OrchestratorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "EmbedRow",
+			Handler:    _Orchestrator_EmbedRow_Handler,
+		},
+		{
+			MethodName: "EmbedDocument",
+			Handler:    _Orchestrator_EmbedDocument_Handler,
+		},
+		{
+			MethodName: "RAGQuery",
+			Handler:    _Orchestrator_RAGQuery_Handler,
+		},
+		{
+			MethodName: "MultiNodeRAGQuery",
+			Handler:    _Orchestrator_MultiNodeRAGQuery_Handler,
+		},
+		{
+			MethodName: "SQLQuery",
+			Handler:    _Orchestrator_SQLQuery_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Chat",
+			Handler:       _Orchestrator_Chat_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "orchestrator/v1/orchestrator.proto",
+}